@@ -60,6 +60,7 @@ type (
 		HTTP         HTTP
 		Jsonnet      Jsonnet
 		Starlark     Starlark
+		Template     Template
 		Logging      Logging
 		Prometheus   Prometheus
 		Proxy        Proxy
@@ -152,6 +153,12 @@ type (
 		SizeLimit uint64 `envconfig:"DRONE_STARLARK_SIZE_LIMIT" default:"0"`
 	}
 
+	// Template configures the template datastore
+	Template struct {
+		NegativeCacheSize int           `envconfig:"DRONE_TEMPLATE_NEGATIVE_CACHE_SIZE"`
+		NegativeCacheTTL  time.Duration `envconfig:"DRONE_TEMPLATE_NEGATIVE_CACHE_TTL" default:"1m"`
+	}
+
 	// License provides license configuration
 	License struct {
 		Key      string `envconfig:"DRONE_LICENSE"`
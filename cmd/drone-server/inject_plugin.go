@@ -94,6 +94,7 @@ func provideConvertPlugin(client *scm.Client, fileService core.FileService, conf
 			templateStore,
 			conf.Starlark.StepLimit,
 			conf.Starlark.SizeLimit,
+			converter.TemplateFileService(fileService),
 		),
 		converter.Memoize(
 			converter.Remote(
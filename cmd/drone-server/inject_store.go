@@ -18,6 +18,7 @@ import (
 	"github.com/drone/drone/cmd/drone-server/config"
 	"github.com/drone/drone/core"
 	"github.com/drone/drone/metric"
+	"github.com/drone/drone/plugin/converter"
 	"github.com/drone/drone/store/batch"
 	"github.com/drone/drone/store/batch2"
 	"github.com/drone/drone/store/build"
@@ -56,7 +57,7 @@ var storeSet = wire.NewSet(
 	secret.New,
 	global.New,
 	step.New,
-	template.New,
+	provideTemplateStore,
 )
 
 // provideDatabase is a Wire provider function that provides a
@@ -179,3 +180,19 @@ func provideUserStore(db *db.DB, enc encrypt.Encrypter, config config.Config) co
 	metric.UserCount(users)
 	return users
 }
+
+// provideTemplateStore is a Wire provider function that provides
+// a template datastore, configured from the environment. Templates
+// not found are optionally cached, and the cache is invalidated when
+// templates are created or updated using the datastore.
+func provideTemplateStore(db *db.DB, config config.Config) core.TemplateStore {
+	templates := template.New(db)
+	if config.Template.NegativeCacheSize > 0 {
+		return converter.NegativeCache(
+			templates,
+			config.Template.NegativeCacheSize,
+			config.Template.NegativeCacheTTL,
+		)
+	}
+	return templates
+}
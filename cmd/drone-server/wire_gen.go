@@ -26,7 +26,6 @@ import (
 	"github.com/drone/drone/store/secret"
 	"github.com/drone/drone/store/secret/global"
 	"github.com/drone/drone/store/step"
-	"github.com/drone/drone/store/template"
 	"github.com/drone/drone/trigger"
 	cron2 "github.com/drone/drone/trigger/cron"
 )
@@ -70,7 +69,7 @@ func InitializeApplication(config2 config.Config) (application, error) {
 	coreCanceler := canceler.New(buildStore, corePubsub, repositoryStore, scheduler, stageStore, statusService, stepStore, userStore, webhookSender)
 	fileService := provideContentService(client, renewer)
 	configService := provideConfigPlugin(client, fileService, config2)
-	templateStore := template.New(db)
+	templateStore := provideTemplateStore(db, config2)
 	convertService := provideConvertPlugin(client, fileService, config2, templateStore)
 	validateService := provideValidatePlugin(config2)
 	triggerer := trigger.New(coreCanceler, configService, convertService, commitService, statusService, buildStore, scheduler, repositoryStore, userStore, validateService, webhookSender)
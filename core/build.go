@@ -53,6 +53,10 @@ type Build struct {
 	Updated      int64             `db:"build_updated"        json:"updated"`
 	Version      int64             `db:"build_version"        json:"version"`
 	Stages       []*Stage          `db:"-"                    json:"stages,omitempty"`
+
+	// Labels are attached to the build by the host (e.g.
+	// from webhook metadata) and are not persisted.
+	Labels map[string]string `db:"-" json:"labels,omitempty"`
 }
 
 // BuildStore defines operations for working with builds.
@@ -16,6 +16,10 @@ package core
 
 import "context"
 
+// ConfigKindSkip is the configuration kind returned by a
+// conversion service to indicate the build should be skipped.
+const ConfigKindSkip = "skip"
+
 type (
 	// Config represents a pipeline config file.
 	Config struct {
@@ -24,6 +24,10 @@ type (
 		Repo   *Repository `json:"repo,omitempty"`
 		Build  *Build      `json:"build,omitempty"`
 		Config *Config     `json:"config,omitempty"`
+
+		// History optionally provides the prior builds of
+		// the repository, most recent first.
+		History []*Build `json:"history,omitempty"`
 	}
 
 	// ConvertService converts non-native pipeline
@@ -16,6 +16,7 @@ package core
 
 import (
 	"context"
+	"time"
 
 	"github.com/drone/drone/handler/api/errors"
 )
@@ -30,6 +31,30 @@ type (
 		Kind string
 		Load string
 		Data map[string]interface{}
+
+		// Include is the path of a file in the repository
+		// that is rendered in place of a stored template.
+		Include string
+
+		// Cache configures caching of the rendered
+		// configuration file.
+		Cache TemplateCache
+
+		// Produces is the kind of the documents the
+		// template is expected to produce (e.g. pipeline).
+		Produces string
+
+		// RequiresSecrets lists the names of the secrets
+		// the template expects to exist.
+		RequiresSecrets []string `yaml:"requires_secrets"`
+	}
+
+	// TemplateCache configures caching of the rendered
+	// configuration file.
+	TemplateCache struct {
+		// TTL is the duration the rendered configuration
+		// file is cached when the input is unchanged.
+		TTL time.Duration
 	}
 
 	Template struct {
@@ -0,0 +1,97 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Cache is a key-value cache used to cache converted configuration
+// files and templates not found. Values are opaque bytes, so the
+// cache may be backed by an external store (e.g. redis). A cache
+// is best effort; implementations should log failures instead of
+// returning them, and must be safe for concurrent use.
+type Cache interface {
+	// Get returns the value of the key, and false if the key
+	// does not exist or has expired.
+	Get(ctx context.Context, key string) ([]byte, bool)
+
+	// Set sets the value of the key, which expires after
+	// the ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+
+	// Delete removes the key.
+	Delete(ctx context.Context, key string)
+}
+
+// MemoryCache returns an in-memory cache that holds up to the
+// given number of entries, evicting the least recently used. If
+// the size is not positive, the cache does not store entries.
+func MemoryCache(size int) Cache {
+	cache, err := lru.New(size)
+	if err != nil {
+		return noopCache{}
+	}
+	return &memoryCache{cache: cache, now: time.Now}
+}
+
+type memoryCache struct {
+	cache *lru.Cache
+	now   func() time.Time
+}
+
+// memoryCacheEntry is a value in the in-memory cache.
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	v, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := v.(*memoryCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.cache.Remove(key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	c.cache.Add(key, &memoryCacheEntry{
+		value:   value,
+		expires: c.now().Add(ttl),
+	})
+}
+
+func (c *memoryCache) Delete(ctx context.Context, key string) {
+	c.cache.Remove(key)
+}
+
+// noopCache is a cache that does not store entries.
+type noopCache struct{}
+
+func (noopCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	return nil, false
+}
+
+func (noopCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {}
+
+func (noopCache) Delete(ctx context.Context, key string) {}
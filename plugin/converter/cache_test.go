@@ -0,0 +1,88 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	now := time.Now()
+	cache := MemoryCache(10).(*memoryCache)
+	cache.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	if _, ok := cache.Get(ctx, "foo"); ok {
+		t.Errorf("Want cache miss for unknown key")
+	}
+
+	cache.Set(ctx, "foo", []byte("bar"), time.Minute)
+	if got, ok := cache.Get(ctx, "foo"); !ok || string(got) != "bar" {
+		t.Errorf("Want cached value bar, got %q", got)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get(ctx, "foo"); ok {
+		t.Errorf("Want cache miss for expired key")
+	}
+
+	cache.Set(ctx, "foo", []byte("bar"), time.Minute)
+	cache.Delete(ctx, "foo")
+	if _, ok := cache.Get(ctx, "foo"); ok {
+		t.Errorf("Want cache miss for deleted key")
+	}
+}
+
+func TestMemoryCacheZeroSize(t *testing.T) {
+	ctx := context.Background()
+	for _, size := range []int{0, -1} {
+		cache := MemoryCache(size)
+		cache.Set(ctx, "foo", []byte("bar"), time.Minute)
+		if _, ok := cache.Get(ctx, "foo"); ok {
+			t.Errorf("Want cache miss for cache of size %d", size)
+		}
+		cache.Delete(ctx, "foo")
+	}
+}
+
+// fakeCache is a cache that records the keys set.
+type fakeCache struct {
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{
+		values: map[string][]byte{},
+		ttls:   map[string]time.Duration{},
+	}
+}
+
+func (c *fakeCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	v, ok := c.values[key]
+	return v, ok
+}
+
+func (c *fakeCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	c.values[key] = value
+	c.ttls[key] = ttl
+}
+
+func (c *fakeCache) Delete(ctx context.Context, key string) {
+	delete(c.values, key)
+	delete(c.ttls, key)
+}
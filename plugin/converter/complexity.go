@@ -0,0 +1,118 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !oss
+
+package converter
+
+import (
+	"fmt"
+
+	"github.com/drone/drone/core"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+	"go.starlark.net/syntax"
+)
+
+// maxLoopWeight caps the weight of a node nested in loops,
+// which doubles with each enclosing loop.
+const maxLoopWeight = 1 << 20
+
+// checkComplexity returns an error if the static complexity of
+// the template exceeds the maximum. The complexity is computed
+// from the syntax tree of starlark and jsonnet templates before
+// they are executed; yaml templates are not checked. Templates
+// that cannot be parsed are not checked, and the syntax error is
+// reported by the engine.
+func checkComplexity(template *core.Template, engine string, max int) error {
+	var complexity int
+	var err error
+	switch engine {
+	case engineStarlark:
+		complexity, err = starlarkComplexity(template.Name, template.Data)
+	case engineJsonnet:
+		complexity, err = jsonnetComplexity(template.Name, template.Data)
+	default:
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	if complexity > max {
+		return fmt.Errorf("template converter: template %s has a complexity of %d, exceeding the maximum of %d", template.Name, complexity, max)
+	}
+	return nil
+}
+
+// starlarkComplexity returns the complexity of the starlark
+// script, which is the number of nodes in the syntax tree. The
+// weight of a node doubles for each enclosing loop or
+// comprehension, so that deeply nested loops are expensive.
+func starlarkComplexity(name, data string) (int, error) {
+	file, err := syntax.Parse(name, data, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// the walk function is called with nil after the
+	// children of a node are visited, which is used to
+	// track the enclosing loops.
+	var complexity int
+	var loops []bool
+	weight := 1
+	syntax.Walk(file, func(n syntax.Node) bool {
+		if n == nil {
+			if loops[len(loops)-1] {
+				weight /= 2
+			}
+			loops = loops[:len(loops)-1]
+			return true
+		}
+		complexity += weight
+		var loop bool
+		switch n.(type) {
+		case *syntax.ForStmt, *syntax.WhileStmt, *syntax.Comprehension:
+			loop = true
+		}
+		if loop && weight < maxLoopWeight {
+			weight *= 2
+		} else {
+			loop = false
+		}
+		loops = append(loops, loop)
+		return true
+	})
+	return complexity, nil
+}
+
+// jsonnetComplexity returns the complexity of the jsonnet file,
+// which is the number of nodes in the desugared syntax tree.
+func jsonnetComplexity(name, data string) (int, error) {
+	node, err := jsonnet.SnippetToAST(name, data)
+	if err != nil {
+		return 0, err
+	}
+	var complexity int
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		complexity++
+		for _, child := range toolutils.Children(node) {
+			walk(child)
+		}
+	}
+	walk(node)
+	return complexity, nil
+}
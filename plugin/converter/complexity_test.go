@@ -0,0 +1,67 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !oss
+
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStarlarkComplexity(t *testing.T) {
+	flat := "def main(ctx):\n  for a in range(10):\n    x = a\n  for b in range(10):\n    x = b\n  for c in range(10):\n    x = c\n  return {'kind': 'pipeline', 'name': 'default'}\n"
+	nested := "def main(ctx):\n  for a in range(10):\n    for b in range(10):\n      for c in range(10):\n        x = a + b + c\n  return {'kind': 'pipeline', 'name': 'default'}\n"
+
+	flatComplexity, err := starlarkComplexity("flat.star", flat)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	nestedComplexity, err := starlarkComplexity("nested.star", nested)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the scripts have a similar number of nodes, however,
+	// the nodes of nested loops are weighted.
+	if nestedComplexity <= flatComplexity {
+		t.Errorf("Want nested loops more complex than sequential loops, got %d <= %d", nestedComplexity, flatComplexity)
+	}
+
+	if _, err := starlarkComplexity("invalid.star", "def main(ctx)\n"); err == nil {
+		t.Errorf("Want syntax error")
+	}
+}
+
+func TestJsonnetComplexity(t *testing.T) {
+	small := "{kind: 'pipeline', name: 'default'}"
+	large := "{kind: 'pipeline', name: 'default', steps: [" + strings.Repeat("{name: 'build', image: 'golang', commands: ['go build', 'go test']},", 50) + "]}"
+
+	smallComplexity, err := jsonnetComplexity("small.jsonnet", small)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	largeComplexity, err := jsonnetComplexity("large.jsonnet", large)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if largeComplexity <= smallComplexity*10 {
+		t.Errorf("Want large file more complex than small file, got %d and %d", largeComplexity, smallComplexity)
+	}
+}
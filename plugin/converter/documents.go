@@ -0,0 +1,223 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// KeyOrder defines the order of map keys in canonicalized
+// configuration files.
+type KeyOrder int
+
+// KeyOrder enumeration.
+const (
+	// KeyOrderNone disables canonicalization.
+	KeyOrderNone KeyOrder = iota
+
+	// KeyOrderSource preserves the order of keys in the
+	// source document.
+	KeyOrderSource
+
+	// KeyOrderSorted sorts keys alphabetically.
+	KeyOrderSorted
+)
+
+// SeparatorStyle defines the placement of document separators
+// (---) in rendered configuration files.
+type SeparatorStyle int
+
+// SeparatorStyle enumeration.
+const (
+	// SeparatorPreserve preserves the document separators of
+	// the rendered configuration file.
+	SeparatorPreserve SeparatorStyle = iota
+
+	// SeparatorLeading precedes each document, including the
+	// first document, with a separator.
+	SeparatorLeading
+
+	// SeparatorBetween places separators between documents
+	// only, omitting the separator before the first document.
+	SeparatorBetween
+)
+
+// applySeparators rewrites the document separators of the
+// configuration file using the separator style. Empty documents
+// are removed.
+func applySeparators(data string, style SeparatorStyle) string {
+	if style == SeparatorPreserve {
+		return data
+	}
+	docs := splitDocuments(data)
+	if len(docs) == 0 {
+		return data
+	}
+	var buf strings.Builder
+	for i, doc := range docs {
+		if style == SeparatorLeading || i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.WriteString(doc)
+		if !strings.HasSuffix(doc, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// DocumentKinds returns the kind of each document in the
+// configuration file, in order. Empty documents are skipped, and
+// documents without a kind are returned as an empty string.
+func DocumentKinds(config string) ([]string, error) {
+	docs, err := decodeDocuments(config)
+	if err != nil {
+		return nil, err
+	}
+	kinds := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		kind, _ := lookup(doc, "kind").(string)
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// RawPipelines returns the pipeline documents in the configuration
+// file verbatim, in order. Template documents and documents of
+// other kinds are skipped.
+func RawPipelines(config string) ([]string, error) {
+	pipelines := []string{}
+	for i, doc := range splitDocuments(config) {
+		var header struct {
+			Kind string
+		}
+		if err := yaml.Unmarshal([]byte(doc), &header); err != nil {
+			return nil, fmt.Errorf("invalid document %d: %w", i+1, err)
+		}
+		if header.Kind == "pipeline" {
+			pipelines = append(pipelines, doc)
+		}
+	}
+	return pipelines, nil
+}
+
+// splitDocuments splits the yaml configuration file into
+// documents. Empty documents are skipped.
+func splitDocuments(data string) []string {
+	var docs []string
+	var doc strings.Builder
+	flush := func() {
+		if strings.TrimSpace(doc.String()) != "" {
+			docs = append(docs, doc.String())
+		}
+		doc.Reset()
+	}
+	for _, line := range splitLines(data) {
+		if strings.TrimRight(line, " \r\n") == "---" {
+			flush()
+			continue
+		}
+		doc.WriteString(line)
+	}
+	flush()
+	return docs
+}
+
+// splitLines splits the text into lines, retaining the
+// trailing newline of each line.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// decodeDocuments decodes each document in the rendered
+// configuration, preserving the order of keys. Empty documents
+// are skipped, and decoding errors identify the document.
+func decodeDocuments(data string) ([]yaml.MapSlice, error) {
+	var docs []yaml.MapSlice
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	for i := 1; ; i++ {
+		var doc yaml.MapSlice
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid document %d: %w", i, err)
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// lookup returns the value of the key in the document.
+func lookup(doc yaml.MapSlice, key string) interface{} {
+	for _, item := range doc {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// sortKeys sorts the keys of the document, and of any nested
+// maps, alphabetically.
+func sortKeys(doc yaml.MapSlice) bool {
+	sort.SliceStable(doc, func(i, j int) bool {
+		return fmt.Sprint(doc[i].Key) < fmt.Sprint(doc[j].Key)
+	})
+	for _, item := range doc {
+		sortValue(item.Value)
+	}
+	return true
+}
+
+// sortValue sorts the keys of the maps nested in the value.
+func sortValue(value interface{}) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		sortKeys(v)
+	case []interface{}:
+		for _, item := range v {
+			sortValue(item)
+		}
+	}
+}
+
+// encodeDocuments encodes the documents as a multi-document
+// yaml configuration.
+func encodeDocuments(docs []yaml.MapSlice) (string, error) {
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString("---\n")
+		buf.Write(out)
+	}
+	return buf.String(), nil
+}
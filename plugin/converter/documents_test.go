@@ -0,0 +1,137 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocumentKinds(t *testing.T) {
+	config := `---
+kind: pipeline
+name: default
+---
+---
+kind: secret
+name: token
+---
+name: untyped
+---
+kind: cron
+name: nightly
+`
+	kinds, err := DocumentKinds(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []string{"pipeline", "secret", "", "cron"}
+	if strings.Join(kinds, ",") != strings.Join(want, ",") || len(kinds) != len(want) {
+		t.Errorf("Want kinds %q got %q", want, kinds)
+	}
+}
+
+func TestDocumentKindsEmpty(t *testing.T) {
+	kinds, err := DocumentKinds("")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(kinds) != 0 {
+		t.Errorf("Want no kinds for an empty config, got %q", kinds)
+	}
+}
+
+func TestDocumentKindsInvalid(t *testing.T) {
+	_, err := DocumentKinds("kind: pipeline\n---\nkind: [secret\n")
+	if err == nil {
+		t.Errorf("Want error for an invalid document")
+	} else if !strings.HasPrefix(err.Error(), "invalid document 2:") {
+		t.Errorf("Want error to identify document 2, got %q", err)
+	}
+}
+
+func TestRawPipelines(t *testing.T) {
+	config := `---
+kind: template
+load: plugin.yaml
+data:
+  name: test
+---
+kind: pipeline
+name: build
+
+steps:
+- name: test
+  image: golang
+---
+kind: secret
+name: token
+---
+kind: pipeline
+name: deploy
+`
+	want := []string{
+		"kind: pipeline\nname: build\n\nsteps:\n- name: test\n  image: golang\n",
+		"kind: pipeline\nname: deploy\n",
+	}
+	got, err := RawPipelines(config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(got) != len(want) {
+		t.Errorf("Want %d pipelines got %d", len(want), len(got))
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Want pipeline %q got %q", want[i], got[i])
+		}
+	}
+}
+
+func TestRawPipelinesInvalid(t *testing.T) {
+	_, err := RawPipelines("kind: pipeline\n---\nkind: [pipeline\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid document 2:") {
+		t.Errorf("Want invalid document error got %v", err)
+	}
+}
+
+func TestApplySeparators(t *testing.T) {
+	data := "kind: pipeline\nname: build\n---\n---\nkind: pipeline\nname: deploy\n---\nkind: secret\nname: token"
+	tests := []struct {
+		style SeparatorStyle
+		want  string
+	}{
+		{
+			style: SeparatorPreserve,
+			want:  data,
+		},
+		{
+			style: SeparatorLeading,
+			want:  "---\nkind: pipeline\nname: build\n---\nkind: pipeline\nname: deploy\n---\nkind: secret\nname: token\n",
+		},
+		{
+			style: SeparatorBetween,
+			want:  "kind: pipeline\nname: build\n---\nkind: pipeline\nname: deploy\n---\nkind: secret\nname: token\n",
+		},
+	}
+	for _, test := range tests {
+		if got := applySeparators(data, test.style); got != test.want {
+			t.Errorf("Want separator style %d %q got %q", test.style, test.want, got)
+		}
+	}
+}
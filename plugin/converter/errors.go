@@ -0,0 +1,60 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import "errors"
+
+// UserError indicates the conversion failed due to a problem
+// with the configuration file or template (e.g. a syntax error).
+// Retrying the conversion will not succeed.
+type UserError struct {
+	Err error
+}
+
+func (e *UserError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *UserError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError indicates the conversion failed due to a problem
+// with the server (e.g. a datastore timeout). The conversion may
+// succeed if retried.
+type ServerError struct {
+	Err error
+}
+
+func (e *ServerError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ServerError) Unwrap() error {
+	return e.Err
+}
+
+// userError wraps the error as a UserError unless the error
+// has already been classified.
+func userError(err error) error {
+	var userErr *UserError
+	var serverErr *ServerError
+	if errors.As(err, &userErr) || errors.As(err, &serverErr) {
+		return err
+	}
+	return &UserError{Err: err}
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/drone/drone/core"
+)
+
+// ConvertInfo provides details about a conversion.
+type ConvertInfo struct {
+	// Checksum is the hex-encoded sha256 checksum of the
+	// converted configuration file.
+	Checksum string
+
+	// Skip is true if the rendered configuration file
+	// requested that the build be skipped.
+	Skip bool
+
+	// Warnings lists the checks that failed in warning
+	// mode during conversion.
+	Warnings []string
+
+	// EnginesUsed lists the engines used to render the
+	// configuration file (e.g. yaml, jsonnet), in sorted
+	// order without duplicates.
+	EnginesUsed []string
+
+	// RequiredSecrets lists the secrets the loaded templates
+	// declare they require, in sorted order without duplicates.
+	RequiredSecrets []string
+
+	// Diagnostics lists the diagnostic output of the engines
+	// (e.g. starlark print statements), prefixed with the
+	// template name, in the order written.
+	Diagnostics []string
+
+	// Trace lists the builtin functions called by starlark
+	// templates, prefixed with the template name, in the order
+	// called. It is only recorded if tracing is enabled.
+	Trace []string
+
+	// Templates lists the templates used to render the
+	// configuration file, in the order rendered.
+	Templates []TemplateUsage
+
+	// stored lists the templates loaded from the datastore,
+	// which are periodically checked for changes while a
+	// cached configuration file is used.
+	stored []TemplateUsage
+}
+
+// TemplateUsage describes a template used to render the
+// configuration file.
+type TemplateUsage struct {
+	// Name and Namespace identify the template.
+	Name      string
+	Namespace string
+
+	// Version is the unix timestamp the template was last
+	// updated, if known.
+	Version int64
+
+	// Checksum is the hex-encoded sha256 checksum of the
+	// template data.
+	Checksum string
+
+	// Engine is the engine used to render the template.
+	Engine string
+
+	// Rendered is the time the template was rendered.
+	Rendered time.Time
+}
+
+// addEngine adds the engine to the set of engines used.
+func (i *ConvertInfo) addEngine(engine string) {
+	n := sort.SearchStrings(i.EnginesUsed, engine)
+	if n < len(i.EnginesUsed) && i.EnginesUsed[n] == engine {
+		return
+	}
+	i.EnginesUsed = append(i.EnginesUsed, "")
+	copy(i.EnginesUsed[n+1:], i.EnginesUsed[n:])
+	i.EnginesUsed[n] = engine
+}
+
+// addSecret adds the secret to the set of required secrets.
+func (i *ConvertInfo) addSecret(name string) {
+	n := sort.SearchStrings(i.RequiredSecrets, name)
+	if n < len(i.RequiredSecrets) && i.RequiredSecrets[n] == name {
+		return
+	}
+	i.RequiredSecrets = append(i.RequiredSecrets, "")
+	copy(i.RequiredSecrets[n+1:], i.RequiredSecrets[n:])
+	i.RequiredSecrets[n] = name
+}
+
+// CheckMode defines how a failed conversion check is reported.
+type CheckMode int
+
+// CheckMode enumeration.
+const (
+	// CheckOff disables the check.
+	CheckOff CheckMode = iota
+
+	// CheckWarn reports a failed check as a warning.
+	CheckWarn
+
+	// CheckError fails the conversion when the check fails.
+	CheckError
+)
+
+// EmptyConfigMode defines how an empty configuration file is
+// converted.
+type EmptyConfigMode string
+
+// EmptyConfigMode enumeration.
+const (
+	// EmptyConfigSkip skips conversion of the empty
+	// configuration file.
+	EmptyConfigSkip EmptyConfigMode = "skip"
+
+	// EmptyConfigError fails the conversion.
+	EmptyConfigError EmptyConfigMode = "error"
+
+	// EmptyConfigDefault converts the empty configuration
+	// file to a default pipeline.
+	EmptyConfigDefault EmptyConfigMode = "default"
+)
+
+// InfoConverter is a conversion service that reports details
+// about the conversion alongside the converted configuration.
+type InfoConverter interface {
+	core.ConvertService
+
+	// ConvertWithInfo converts the configuration file and
+	// returns details about the conversion.
+	ConvertWithInfo(context.Context, *core.ConvertArgs) (*core.Config, *ConvertInfo, error)
+}
@@ -44,7 +44,7 @@ func (p *jsonnetPlugin) Convert(ctx context.Context, req *core.ConvertArgs) (*co
 		return nil, nil
 	}
 
-	file, err := jsonnet.Parse(req, p.fileService, p.limit, nil, nil)
+	file, err := jsonnet.Parse(req, p.fileService, p.limit, nil, nil, nil)
 
 	if err != nil {
 		return nil, err
@@ -17,6 +17,7 @@ import (
 const repo = "repo."
 const build = "build."
 const param = "param."
+const label = "labels."
 
 var noContext = context.Background()
 
@@ -87,7 +88,11 @@ func (i *importer) Import(importedFrom, importedPath string) (contents jsonnet.C
 	return i.cache[importedPath], importedPath, err
 }
 
-func Parse(req *core.ConvertArgs, fileService core.FileService, limit int, template *core.Template, templateData map[string]interface{}) (string, error) {
+// Parse evaluates the jsonnet file and returns the generated
+// configuration file. The scope provides additional values that
+// are exposed to the file as external variables alongside the
+// repository, build and input (e.g. organization metadata).
+func Parse(req *core.ConvertArgs, fileService core.FileService, limit int, template *core.Template, templateData map[string]interface{}, scope map[string]interface{}) (string, error) {
 	vm := jsonnet.MakeVM()
 	vm.MaxStack = 500
 	vm.StringOutput = false
@@ -107,6 +112,8 @@ func Parse(req *core.ConvertArgs, fileService core.FileService, limit int, templ
 	//map build/repo parameters
 	if req.Build != nil {
 		mapBuild(req.Build, vm)
+	} else {
+		mapBuild(new(core.Build), vm)
 	}
 	if req.Repo != nil {
 		mapRepo(req.Repo, vm)
@@ -130,6 +137,11 @@ func Parse(req *core.ConvertArgs, fileService core.FileService, limit int, templ
 		}
 	}
 
+	// map additional scope values
+	for name, value := range scope {
+		fromScope(name, value, vm)
+	}
+
 	// convert the jsonnet file to yaml
 	buf := new(bytes.Buffer)
 	docs, err := vm.EvaluateAnonymousSnippetStream(jsonnetFileName, jsonnetFile)
@@ -155,6 +167,7 @@ func Parse(req *core.ConvertArgs, fileService core.FileService, limit int, templ
 func mapBuild(v *core.Build, vm *jsonnet.VM) {
 	vm.ExtVar(build+"event", v.Event)
 	vm.ExtVar(build+"action", v.Action)
+	vm.ExtVar(build+"trigger", v.Trigger)
 	vm.ExtVar(build+"environment", v.Deploy)
 	vm.ExtVar(build+"link", v.Link)
 	vm.ExtVar(build+"branch", v.Target)
@@ -168,12 +181,16 @@ func mapBuild(v *core.Build, vm *jsonnet.VM) {
 	vm.ExtVar(build+"title", v.Title)
 	vm.ExtVar(build+"message", v.Message)
 	vm.ExtVar(build+"source_repo", v.Fork)
+	vm.ExtVar(build+"author", v.AuthorName)
 	vm.ExtVar(build+"author_login", v.Author)
 	vm.ExtVar(build+"author_name", v.AuthorName)
 	vm.ExtVar(build+"author_email", v.AuthorEmail)
 	vm.ExtVar(build+"author_avatar", v.AuthorAvatar)
 	vm.ExtVar(build+"sender", v.Sender)
 	fromMap(v.Params, vm)
+	for key, val := range v.Labels {
+		vm.ExtVar(build+label+key, val)
+	}
 }
 
 func mapRepo(v *core.Repository, vm *jsonnet.VM) {
@@ -185,6 +202,7 @@ func mapRepo(v *core.Repository, vm *jsonnet.VM) {
 	vm.ExtVar(repo+"git_ssh_url", v.SSHURL)
 	vm.ExtVar(repo+"link", v.Link)
 	vm.ExtVar(repo+"branch", v.Branch)
+	vm.ExtVar(repo+"default_branch", v.Branch)
 	vm.ExtVar(repo+"config", v.Config)
 	vm.ExtVar(repo+"private", strconv.FormatBool(v.Private))
 	vm.ExtVar(repo+"visibility", v.Visibility)
@@ -195,6 +213,18 @@ func mapRepo(v *core.Repository, vm *jsonnet.VM) {
 	vm.ExtVar(repo+"ignore_pull_requests", strconv.FormatBool(v.IgnorePulls))
 }
 
+func fromScope(name string, value interface{}, vm *jsonnet.VM) {
+	if m, ok := value.(map[string]interface{}); ok {
+		for k, v := range m {
+			vm.ExtVar(name+"."+k, fmt.Sprint(v))
+		}
+		return
+	}
+	if value != nil {
+		vm.ExtVar(name, fmt.Sprint(value))
+	}
+}
+
 func fromMap(m map[string]string, vm *jsonnet.VM) {
 	for k, v := range m {
 		vm.ExtVar(build+param+k, v)
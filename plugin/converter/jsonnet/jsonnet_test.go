@@ -46,7 +46,7 @@ func TestParse(t *testing.T) {
 
 	req.Config.Data = string(before)
 
-	got, err := Parse(req, nil, 0, template, templateData)
+	got, err := Parse(req, nil, 0, template, templateData, nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -90,7 +90,7 @@ func TestParseJsonnetNotTemplateFile(t *testing.T) {
 	req.Repo.Config = "plugin.jsonnet"
 	req.Config.Data = string(before)
 
-	got, err := Parse(req, nil, 0, nil, nil)
+	got, err := Parse(req, nil, 0, nil, nil, nil)
 	if err != nil {
 		t.Error(err)
 		return
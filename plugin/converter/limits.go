@@ -0,0 +1,47 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import "context"
+
+// Limits overrides the limits applied when rendering starlark
+// templates. Zero values fall back to the limits configured for
+// the plugin.
+type Limits struct {
+	// StepLimit is the maximum number of execution steps
+	// of a starlark template.
+	StepLimit uint64
+
+	// SizeLimit is the maximum size, in bytes, of the
+	// configuration generated by a starlark template.
+	SizeLimit uint64
+}
+
+type limitsKey struct{}
+
+// WithLimits returns a copy of the context that overrides the
+// limits applied when rendering templates. The overrides must
+// only be set by trusted callers (e.g. conversions triggered by
+// an administrator), and are ignored for builds triggered by a
+// webhook.
+func WithLimits(ctx context.Context, limits Limits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, limits)
+}
+
+// limitsFrom returns the limit overrides from the context.
+func limitsFrom(ctx context.Context) (Limits, bool) {
+	limits, ok := ctx.Value(limitsKey{}).(Limits)
+	return limits, ok
+}
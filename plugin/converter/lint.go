@@ -0,0 +1,96 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/drone/drone/core"
+
+	"github.com/drone/drone-yaml/yaml"
+	"github.com/drone/drone-yaml/yaml/linter"
+)
+
+// LintFunc lints the rendered configuration file, returning the
+// lint rules the configuration fails.
+type LintFunc func(ctx context.Context, repo *core.Repository, data string) []*LintError
+
+// LintError describes a lint rule failed by a rendered
+// configuration file.
+type LintError struct {
+	// Document is the 1-based index of the document that
+	// failed the rule, or 0 if unknown.
+	Document int
+
+	// Name is the name of the document that failed the
+	// rule, if known.
+	Name string
+
+	// Message describes the failed rule.
+	Message string
+}
+
+func (e *LintError) Error() string {
+	switch {
+	case e.Name != "":
+		return fmt.Sprintf("%s (document %d, %q)", e.Message, e.Document, e.Name)
+	case e.Document != 0:
+		return fmt.Sprintf("%s (document %d)", e.Message, e.Document)
+	default:
+		return e.Message
+	}
+}
+
+// LintErrors is returned when a rendered configuration file
+// fails one or more lint rules.
+type LintErrors []*LintError
+
+func (e LintErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "template converter: lint failed: " + strings.Join(messages, "; ")
+}
+
+// DroneLinter lints the rendered configuration file using the
+// lint rules applied by Drone when the build is created.
+func DroneLinter(ctx context.Context, repo *core.Repository, data string) []*LintError {
+	manifest, err := yaml.ParseString(data)
+	if err != nil {
+		return []*LintError{{Message: err.Error()}}
+	}
+	var errs []*LintError
+	for i, resource := range manifest.Resources {
+		err := linter.Lint(resource, repo.Trusted)
+		if err == nil {
+			continue
+		}
+		lintErr := &LintError{Document: i + 1, Message: err.Error()}
+		if pipeline, ok := resource.(*yaml.Pipeline); ok {
+			lintErr.Name = pipeline.Name
+		}
+		errs = append(errs, lintErr)
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	if err := linter.Manifest(manifest, repo.Trusted); err != nil {
+		return []*LintError{{Message: err.Error()}}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/drone/drone/core"
+)
+
+func TestDroneLinter(t *testing.T) {
+	repo := &core.Repository{Slug: "octocat/hello-world"}
+
+	config := "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n"
+	if errs := DroneLinter(context.Background(), repo, config); len(errs) != 0 {
+		t.Errorf("Want valid configuration, got %v", LintErrors(errs))
+	}
+
+	config = "kind: pipeline\nname: default\nsteps:\n- name: build\n"
+	errs := DroneLinter(context.Background(), repo, config)
+	if len(errs) != 1 {
+		t.Errorf("Want 1 lint error got %d", len(errs))
+		return
+	}
+	if got, want := errs[0].Document, 1; got != want {
+		t.Errorf("Want lint error in document %d got %d", want, got)
+	}
+	if got, want := errs[0].Name, "default"; got != want {
+		t.Errorf("Want lint error in pipeline %q got %q", want, got)
+	}
+}
+
+func TestLintErrors(t *testing.T) {
+	errs := LintErrors{
+		{Document: 1, Name: "default", Message: "linter: invalid or missing name"},
+		{Document: 2, Message: "linter: invalid or missing image"},
+		{Message: "linter: duplicate pipeline names"},
+	}
+	want := `template converter: lint failed: linter: invalid or missing name (document 1, "default"); linter: invalid or missing image (document 2); linter: duplicate pipeline names`
+	if got := errs.Error(); got != want {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+}
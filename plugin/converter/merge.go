@@ -0,0 +1,140 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import "gopkg.in/yaml.v2"
+
+// MergeConfigs deep-merges the overlay configuration b into the
+// base configuration a, and returns the merged configuration. The
+// converter does not merge configurations itself; the merge rules
+// are defined here:
+//
+//   - documents are matched by kind and name. Matched documents
+//     are merged, and unmatched overlay documents are appended;
+//   - maps are merged recursively. Base keys keep their order,
+//     and overlay keys missing from the base are appended;
+//   - lists in which every item is a map with a name (e.g. steps)
+//     are merged by name, and unmatched overlay items are appended;
+//   - all other values, including scalars, empty lists and lists
+//     of unnamed items, are replaced by the overlay value.
+//
+// Each document of the merged configuration is preceded by a
+// document separator.
+func MergeConfigs(a, b string) (string, error) {
+	base, err := decodeDocuments(a)
+	if err != nil {
+		return "", err
+	}
+	overlay, err := decodeDocuments(b)
+	if err != nil {
+		return "", err
+	}
+	for _, doc := range overlay {
+		i := indexDocument(base, doc)
+		if i == -1 {
+			base = append(base, doc)
+			continue
+		}
+		base[i] = mergeMaps(base[i], doc)
+	}
+	return encodeDocuments(base)
+}
+
+// indexDocument returns the index of the document with the
+// same kind and name, or -1 if no document matches.
+func indexDocument(docs []yaml.MapSlice, doc yaml.MapSlice) int {
+	kind, _ := lookup(doc, "kind").(string)
+	name, _ := lookup(doc, "name").(string)
+	for i, other := range docs {
+		otherKind, _ := lookup(other, "kind").(string)
+		otherName, _ := lookup(other, "name").(string)
+		if otherKind == kind && otherName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeMaps merges the overlay map into a copy of the base map.
+func mergeMaps(base, overlay yaml.MapSlice) yaml.MapSlice {
+	merged := make(yaml.MapSlice, len(base), len(base)+len(overlay))
+	copy(merged, base)
+	for _, item := range overlay {
+		found := false
+		for i := range merged {
+			if merged[i].Key == item.Key {
+				merged[i].Value = mergeValues(merged[i].Value, item.Value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// mergeValues merges the overlay value into the base value.
+func mergeValues(base, overlay interface{}) interface{} {
+	switch overlay := overlay.(type) {
+	case yaml.MapSlice:
+		if base, ok := base.(yaml.MapSlice); ok {
+			return mergeMaps(base, overlay)
+		}
+	case []interface{}:
+		if base, ok := base.([]interface{}); ok && namedItems(base) && namedItems(overlay) {
+			return mergeNamedItems(base, overlay)
+		}
+	}
+	return overlay
+}
+
+// mergeNamedItems merges the overlay list into a copy of the
+// base list, matching items by name.
+func mergeNamedItems(base, overlay []interface{}) []interface{} {
+	merged := make([]interface{}, len(base), len(base)+len(overlay))
+	copy(merged, base)
+	for _, item := range overlay {
+		name, _ := lookup(item.(yaml.MapSlice), "name").(string)
+		found := false
+		for i := range merged {
+			if other, _ := lookup(merged[i].(yaml.MapSlice), "name").(string); other == name {
+				merged[i] = mergeMaps(merged[i].(yaml.MapSlice), item.(yaml.MapSlice))
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// namedItems returns true if the list is not empty and every
+// item is a map with a name.
+func namedItems(items []interface{}) bool {
+	if len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		m, ok := item.(yaml.MapSlice)
+		if name, _ := lookup(m, "name").(string); !ok || name == "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import "testing"
+
+func TestMergeConfigs(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "scalar",
+			a:    "kind: pipeline\nname: default\ntype: docker\n",
+			b:    "kind: pipeline\nname: default\ntype: kubernetes\n",
+			want: "---\nkind: pipeline\nname: default\ntype: kubernetes\n",
+		},
+		{
+			name: "map",
+			a:    "kind: pipeline\nname: default\nplatform:\n  os: linux\n  arch: amd64\n",
+			b:    "kind: pipeline\nname: default\nplatform:\n  arch: arm64\n  variant: v8\n",
+			want: "---\nkind: pipeline\nname: default\nplatform:\n  os: linux\n  arch: arm64\n  variant: v8\n",
+		},
+		{
+			name: "list",
+			a:    "kind: pipeline\nname: default\ntrigger:\n  branch:\n  - main\n  - develop\n",
+			b:    "kind: pipeline\nname: default\ntrigger:\n  branch:\n  - release\n",
+			want: "---\nkind: pipeline\nname: default\ntrigger:\n  branch:\n  - release\n",
+		},
+		{
+			name: "named list",
+			a:    "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n- name: test\n  image: golang\n",
+			b:    "kind: pipeline\nname: default\nsteps:\n- name: test\n  image: golang:1.16\n- name: publish\n  image: plugins/docker\n",
+			want: "---\nkind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n- name: test\n  image: golang:1.16\n- name: publish\n  image: plugins/docker\n",
+		},
+		{
+			name: "documents",
+			a:    "kind: pipeline\nname: default\n",
+			b:    "kind: secret\nname: token\n",
+			want: "---\nkind: pipeline\nname: default\n---\nkind: secret\nname: token\n",
+		},
+	}
+	for _, test := range tests {
+		got, err := MergeConfigs(test.a, test.b)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: want merged config\n%s\ngot\n%s", test.name, test.want, got)
+		}
+	}
+}
+
+func TestMergeConfigsInvalid(t *testing.T) {
+	_, err := MergeConfigs("kind: pipeline\n", "kind: [pipeline\n")
+	if err == nil {
+		t.Errorf("Expect error when the overlay is invalid yaml")
+	}
+}
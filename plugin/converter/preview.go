@@ -0,0 +1,57 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+
+	"github.com/drone/drone/core"
+)
+
+// PreviewOption configures a preview conversion.
+type PreviewOption func(*previewOptions)
+
+type previewOptions struct {
+	maxSteps int
+}
+
+// MaxStepsPerPipeline returns a preview option that truncates
+// the steps of each pipeline to the first n steps.
+func MaxStepsPerPipeline(n int) PreviewOption {
+	return func(opts *previewOptions) {
+		opts.maxSteps = n
+	}
+}
+
+// Previewer is a conversion service that renders a preview of
+// the converted configuration, for example to display in the
+// user interface. The preview must not be used to run a build.
+type Previewer interface {
+	Preview(context.Context, *core.ConvertArgs, ...PreviewOption) (*core.Config, error)
+}
+
+// Differ is a conversion service that compares two versions of
+// a converted configuration, for example to review how a template
+// change alters the converted configuration.
+type Differ interface {
+	Diff(ctx context.Context, before, after *core.ConvertArgs) (string, error)
+}
+
+// Renderer is a conversion service that renders a named template
+// with the given input, without a configuration file, for example
+// to render an example of the template in a catalog.
+type Renderer interface {
+	RenderByName(ctx context.Context, name, namespace string, data map[string]interface{}, req *core.ConvertArgs) (string, error)
+}
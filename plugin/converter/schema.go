@@ -0,0 +1,124 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParamsSchema declares constraints on the input parameters
+// of a template. A template declares constraints in the comment
+// lines at the top of the template, one group per line:
+//
+//	# one_of: image, build
+//	# any_of: commands, script
+type ParamsSchema struct {
+	// OneOf lists groups of parameters where exactly one
+	// parameter in each group must be set.
+	OneOf [][]string `json:"one_of,omitempty" yaml:"one_of"`
+
+	// AnyOf lists groups of parameters where at least one
+	// parameter in each group must be set.
+	AnyOf [][]string `json:"any_of,omitempty" yaml:"any_of"`
+}
+
+// Validate returns an error if the input parameters violate
+// the schema constraints.
+func (s *ParamsSchema) Validate(params map[string]interface{}) error {
+	for _, group := range s.OneOf {
+		set := setParams(params, group)
+		if len(set) != 1 {
+			return fmt.Errorf("template converter: exactly one of %s must be set, got %d", quoteParams(group), len(set))
+		}
+	}
+	for _, group := range s.AnyOf {
+		if len(setParams(params, group)) == 0 {
+			return fmt.Errorf("template converter: at least one of %s must be set", quoteParams(group))
+		}
+	}
+	return nil
+}
+
+// setParams returns the parameters in the group that are set.
+func setParams(params map[string]interface{}, group []string) []string {
+	var set []string
+	for _, name := range group {
+		if v, ok := params[name]; ok && v != nil {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+// quoteParams returns the quoted, comma-separated parameter
+// names.
+func quoteParams(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// templateSchema returns the params schema declared by the
+// comment lines at the top of the template, or nil if the
+// template does not declare constraints. Comments start with
+// # or //, and other comments in the header are ignored.
+func templateSchema(data string) *ParamsSchema {
+	schema := new(ParamsSchema)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#"):
+			line = line[1:]
+		case strings.HasPrefix(line, "//"):
+			line = line[2:]
+		default:
+			return schemaOrNil(schema)
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var group []string
+		for _, name := range strings.Split(parts[1], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				group = append(group, name)
+			}
+		}
+		if len(group) == 0 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "one_of":
+			schema.OneOf = append(schema.OneOf, group)
+		case "any_of":
+			schema.AnyOf = append(schema.AnyOf, group)
+		}
+	}
+	return schemaOrNil(schema)
+}
+
+// schemaOrNil returns nil if the schema declares no
+// constraints.
+func schemaOrNil(schema *ParamsSchema) *ParamsSchema {
+	if len(schema.OneOf) == 0 && len(schema.AnyOf) == 0 {
+		return nil
+	}
+	return schema
+}
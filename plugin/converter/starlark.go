@@ -55,7 +55,7 @@ func (p *starlarkPlugin) Convert(ctx context.Context, req *core.ConvertArgs) (*c
 		return nil, nil
 	}
 
-	file, err := starlark.Parse(req, nil, nil, p.stepLimit, p.sizeLimit)
+	file, err := starlark.Parse(req, nil, nil, nil, p.stepLimit, p.sizeLimit, nil, nil)
 	if err != nil {
 		return nil, err
 	}
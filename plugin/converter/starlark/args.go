@@ -41,24 +41,56 @@ import (
 // TODO(bradrydzewski) add build parent
 // TODO(bradrydzewski) add build timestamp
 
-func createArgs(repo *core.Repository, build *core.Build, input map[string]interface{}) ([]starlark.Value, error) {
+func createArgs(repo *core.Repository, build *core.Build, input map[string]interface{}, scope map[string]interface{}) ([]starlark.Value, error) {
+	if build == nil {
+		build = new(core.Build)
+	}
 	inputArgs, err := fromInput(input)
 	if err != nil {
 		return nil, err
 	}
+	fields := starlark.StringDict{
+		"repo":  starlarkstruct.FromStringDict(starlark.String("repo"), fromRepo(repo)),
+		"build": starlarkstruct.FromStringDict(starlark.String("build"), fromBuild(build)),
+		"input": starlarkstruct.FromStringDict(starlark.String("input"), inputArgs),
+	}
+	for key, value := range scope {
+		if _, ok := fields[key]; ok {
+			continue
+		}
+		scopeArgs, err := fromScope(key, value)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = scopeArgs
+	}
 	args := []starlark.Value{
 		starlarkstruct.FromStringDict(
 			starlark.String("context"),
-			starlark.StringDict{
-				"repo":  starlarkstruct.FromStringDict(starlark.String("repo"), fromRepo(repo)),
-				"build": starlarkstruct.FromStringDict(starlark.String("build"), fromBuild(build)),
-				"input": starlarkstruct.FromStringDict(starlark.String("input"), inputArgs),
-			},
+			fields,
 		),
 	}
 	return args, nil
 }
 
+// fromScope converts the scope value to a starlark value. Maps
+// are converted to structs so that values can be accessed using
+// dot notation, consistent with the input.
+func fromScope(name string, value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlarkstruct.FromStringDict(starlark.String(name), starlark.StringDict{}), nil
+	case map[string]interface{}:
+		dict, err := fromInput(v)
+		if err != nil {
+			return nil, err
+		}
+		return starlarkstruct.FromStringDict(starlark.String(name), dict), nil
+	default:
+		return toValue(reflect.ValueOf(v))
+	}
+}
+
 func fromInput(input map[string]interface{}) (starlark.StringDict, error) {
 	out := map[string]starlark.Value{}
 	for key, value := range input {
@@ -124,6 +156,7 @@ func fromBuild(v *core.Build) starlark.StringDict {
 		"event":         starlark.String(v.Event),
 		"action":        starlark.String(v.Action),
 		"cron":          starlark.String(v.Cron),
+		"trigger":       starlark.String(v.Trigger),
 		"environment":   starlark.String(v.Deploy),
 		"link":          starlark.String(v.Link),
 		"branch":        starlark.String(v.Target),
@@ -136,6 +169,7 @@ func fromBuild(v *core.Build) starlark.StringDict {
 		"title":         starlark.String(v.Title),
 		"message":       starlark.String(v.Message),
 		"source_repo":   starlark.String(v.Fork),
+		"author":        starlark.String(v.AuthorName),
 		"author_login":  starlark.String(v.Author),
 		"author_name":   starlark.String(v.AuthorName),
 		"author_email":  starlark.String(v.AuthorEmail),
@@ -143,6 +177,7 @@ func fromBuild(v *core.Build) starlark.StringDict {
 		"sender":        starlark.String(v.Sender),
 		"debug":         starlark.Bool(v.Debug),
 		"params":        fromMap(v.Params),
+		"labels":        fromMap(v.Labels),
 	}
 }
 
@@ -156,6 +191,7 @@ func fromRepo(v *core.Repository) starlark.StringDict {
 		"git_ssh_url":          starlark.String(v.SSHURL),
 		"link":                 starlark.String(v.Link),
 		"branch":               starlark.String(v.Branch),
+		"default_branch":       starlark.String(v.Branch),
 		"config":               starlark.String(v.Config),
 		"private":              starlark.Bool(v.Private),
 		"visibility":           starlark.String(v.Visibility),
@@ -16,6 +16,7 @@ package starlark
 
 import (
 	"bytes"
+	"fmt"
 
 	"github.com/drone/drone/core"
 	"github.com/drone/drone/handler/api/errors"
@@ -54,11 +55,23 @@ var (
 	ErrCannotLoad = errors.New("starlark: cannot load external scripts")
 )
 
-func Parse(req *core.ConvertArgs, template *core.Template, templateData map[string]interface{}, stepLimit uint64, sizeLimit uint64) (string, error) {
+// Parse executes the starlark script and returns the generated
+// configuration file. The scope provides additional values that
+// are exposed to the script alongside the repository, build and
+// input (e.g. organization metadata). If the print function is
+// non-nil, it receives the output of the script's print
+// statements, which is otherwise logged. If the trace function
+// is non-nil, it receives a message for each builtin function
+// called by the script, for debugging.
+func Parse(req *core.ConvertArgs, template *core.Template, templateData map[string]interface{}, scope map[string]interface{}, stepLimit uint64, sizeLimit uint64, print func(msg string), trace func(msg string)) (string, error) {
 	thread := &starlark.Thread{
 		Name: "drone",
 		Load: noLoad,
 		Print: func(_ *starlark.Thread, msg string) {
+			if print != nil {
+				print(msg)
+				return
+			}
 			logrus.WithFields(logrus.Fields{
 				"namespace": req.Repo.Namespace,
 				"name":      req.Repo.Name,
@@ -75,7 +88,12 @@ func Parse(req *core.ConvertArgs, template *core.Template, templateData map[stri
 		starlarkFileName = req.Repo.Config
 	}
 
-	globals, err := starlark.ExecFile(thread, starlarkFileName, starlarkFile, nil)
+	var predeclared starlark.StringDict
+	if trace != nil {
+		predeclared = traceBuiltins(trace)
+	}
+
+	globals, err := starlark.ExecFile(thread, starlarkFileName, starlarkFile, predeclared)
 	if err != nil {
 		return "", err
 	}
@@ -94,7 +112,7 @@ func Parse(req *core.ConvertArgs, template *core.Template, templateData map[stri
 
 	// create the input args and invoke the main method
 	// using the input args.
-	args, err := createArgs(req.Repo, req.Build, templateData)
+	args, err := createArgs(req.Repo, req.Build, templateData, scope)
 	if err != nil {
 		return "", err
 	}
@@ -107,7 +125,13 @@ func Parse(req *core.ConvertArgs, template *core.Template, templateData map[stri
 	thread.SetMaxExecutionSteps(stepLimit)
 
 	// execute the main method in the script.
+	if trace != nil {
+		trace(fmt.Sprintf("step %d: main", thread.ExecutionSteps()))
+	}
 	mainVal, err = starlark.Call(thread, main, args, nil)
+	if trace != nil {
+		trace(fmt.Sprintf("step %d: main returned", thread.ExecutionSteps()))
+	}
 	if err != nil {
 		return "", err
 	}
@@ -16,6 +16,8 @@ package starlark
 
 import (
 	"io/ioutil"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/drone/drone/core"
@@ -57,7 +59,7 @@ func TestParseStarlark(t *testing.T) {
 
 	req.Config.Data = string(before)
 
-	parsedFile, err := Parse(req, template, templateData, 0, 0)
+	parsedFile, err := Parse(req, template, templateData, nil, 0, 0, nil, nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -95,7 +97,7 @@ func TestParseStarlarkNotTemplateFile(t *testing.T) {
 	req.Repo.Config = "plugin.starlark.star"
 	req.Config.Data = string(before)
 
-	parsedFile, err := Parse(req, nil, nil, 0, 0)
+	parsedFile, err := Parse(req, nil, nil, nil, 0, 0, nil, nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -105,3 +107,85 @@ func TestParseStarlarkNotTemplateFile(t *testing.T) {
 		t.Errorf("Want %q got %q", want, got)
 	}
 }
+
+func TestParseStarlarkPrint(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:   "octocat/hello-world",
+			Config: ".drone.yml",
+		},
+		Config: &core.Config{},
+	}
+	template := &core.Template{
+		Name: "my_template.star",
+		Data: "print('loading')\ndef main(ctx):\n  print('building %s' % ctx.repo.slug)\n  return {'kind': 'pipeline', 'name': 'default'}\n",
+	}
+
+	var got []string
+	print := func(msg string) {
+		got = append(got, msg)
+	}
+	if _, err := Parse(req, template, nil, nil, 0, 0, print, nil); err != nil {
+		t.Error(err)
+		return
+	}
+	want := []string{"loading", "building octocat/hello-world"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Want print output %q got %q", want, got)
+	}
+}
+
+func TestParseStarlarkTrace(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:   "octocat/hello-world",
+			Config: ".drone.yml",
+		},
+		Config: &core.Config{},
+	}
+	template := &core.Template{
+		Name: "my_template.star",
+		Data: "def steps(n):\n  return [str(i) for i in range(n)]\ndef main(ctx):\n  return {'kind': 'pipeline', 'name': 'default', 'count': len(steps(2))}\n",
+	}
+
+	var got []string
+	trace := func(msg string) {
+		// the step counts and positions are removed, which
+		// depend on the bytecode generated by the compiler.
+		msg = traceStepRE.ReplaceAllString(msg, "")
+		msg = tracePosRE.ReplaceAllString(msg, "")
+		got = append(got, msg)
+	}
+	if _, err := Parse(req, template, nil, nil, 0, 0, nil, trace); err != nil {
+		t.Error(err)
+		return
+	}
+	want := []string{
+		"main",
+		"range in main > steps",
+		"str in main > steps",
+		"str in main > steps",
+		"len in main",
+		"main returned",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Want trace %q got %q", want, got)
+	}
+
+	// the trace does not bypass the step limit.
+	template.Data = "def main(ctx):\n  for i in range(100000):\n    str(i)\n  return {'kind': 'pipeline', 'name': 'default'}\n"
+	if _, err := Parse(req, template, nil, nil, 1000, 0, nil, func(string) {}); err == nil {
+		t.Errorf("Want step limit error")
+	}
+}
+
+var (
+	traceStepRE = regexp.MustCompile(`^step \d+: `)
+	tracePosRE  = regexp.MustCompile(` at \S+`)
+)
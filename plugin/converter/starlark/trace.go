@@ -0,0 +1,67 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package starlark
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// traceBuiltins returns the universal builtins wrapped to report
+// each call to the trace function. The interpreter does not expose
+// a hook for function calls, so the wrapped builtins are declared
+// in place of the universal builtins, and each call reports the
+// call stack of the script.
+func traceBuiltins(trace func(msg string)) starlark.StringDict {
+	predeclared := starlark.StringDict{}
+	for name, v := range starlark.Universe {
+		builtin, ok := v.(*starlark.Builtin)
+		if !ok {
+			continue
+		}
+		predeclared[name] = starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			trace(traceCall(thread, fn.Name()))
+			return starlark.Call(thread, builtin, args, kwargs)
+		})
+	}
+	return predeclared
+}
+
+// traceCall returns the trace message for a call to the named
+// builtin, which includes the number of steps executed, the
+// position of the call and the functions on the call stack
+// (e.g. step 12: len at plugin.star:3:10 in main > steps).
+func traceCall(thread *starlark.Thread, name string) string {
+	stack := thread.CallStack()
+	if len(stack) < 2 {
+		return fmt.Sprintf("step %d: %s", thread.ExecutionSteps(), name)
+	}
+
+	// the innermost frame is the wrapped builtin, and is
+	// excluded from the call stack.
+	stack = stack[:len(stack)-1]
+	funcs := make([]string, len(stack))
+	for i, frame := range stack {
+		funcs[i] = frame.Name
+	}
+	return fmt.Sprintf("step %d: %s at %s in %s",
+		thread.ExecutionSteps(),
+		name,
+		stack[len(stack)-1].Pos,
+		strings.Join(funcs, " > "),
+	)
+}
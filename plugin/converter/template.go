@@ -19,17 +19,28 @@ package converter
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	templating "text/template"
+	"time"
 
 	"github.com/drone/drone/core"
 	"github.com/drone/drone/plugin/converter/jsonnet"
 	"github.com/drone/drone/plugin/converter/starlark"
-	"github.com/drone/funcmap"
+	"github.com/drone/go-scm/scm"
 
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
@@ -39,68 +50,2710 @@ var (
 	errTemplateNotFound         = errors.New("template converter: template name given not found")
 	errTemplateSyntaxErrors     = errors.New("template converter: there is a problem with the yaml file provided")
 	errTemplateExtensionInvalid = errors.New("template extension invalid. must be yaml, starlark or jsonnet")
+	errTemplateIncludeDisabled  = errors.New("template converter: including repository files is not enabled")
+	errTemplateEmptyConfig      = errors.New("template converter: configuration file is empty")
+	errTemplateRequired         = errors.New("template converter: configuration file must be a template (kind: template)")
 )
 
-func Template(templateStore core.TemplateStore, stepLimit uint64, sizeLimit uint64) core.ConvertService {
-	return &templatePlugin{
-		templateStore: templateStore,
-		stepLimit: stepLimit,
-		sizeLimit: sizeLimit,
+// default order in which file extensions are searched when
+// a template is referenced without a file extension.
+var defaultSearchOrder = []string{".yaml", ".yml", ".star", ".starlark", ".script", ".jsonnet"}
+
+// knownKinds lists the document kinds a rendered configuration
+// file may contain.
+var knownKinds = []string{"pipeline", "secret", "signature", "cron"}
+
+// posixSpace lists the characters matched by the posix
+// [[:space:]] character class.
+const posixSpace = " \t\n\v\f\r"
+
+// pipelineFields lists the known top-level fields of pipeline
+// documents, across pipeline types.
+var pipelineFields = []string{
+	"kind",
+	"type",
+	"name",
+	"version",
+	"platform",
+	"node",
+	"pool",
+	"workspace",
+	"clone",
+	"steps",
+	"services",
+	"volumes",
+	"trigger",
+	"depends_on",
+	"image_pull_secrets",
+	"concurrency",
+	"environment",
+	"metadata",
+	"node_selector",
+	"tolerations",
+	"service_account_name",
+	"dns_config",
+	"host_aliases",
+	"server",
+	"token",
+}
+
+// template engines.
+const (
+	engineYaml     = "yaml"
+	engineStarlark = "starlark"
+	engineJsonnet  = "jsonnet"
+)
+
+// templateCacheEntry is a cached configuration file. Checked
+// is the time the templates were last checked for changes.
+type templateCacheEntry struct {
+	Config    core.Config     `json:"config"`
+	Info      ConvertInfo     `json:"info"`
+	Templates []TemplateUsage `json:"templates"`
+	Expires   time.Time       `json:"expires"`
+	Checked   time.Time       `json:"checked"`
+}
+
+// defaultEmptyConfig is the configuration file used in place
+// of an empty configuration file in default mode.
+const defaultEmptyConfig = `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: default
+  image: alpine
+  commands:
+  - echo "no pipeline configured"
+`
+
+// default limit for the number of templates that can be
+// included when rendering a configuration file.
+const defaultMaxDepth = 10
+
+// maximum number of prior builds exposed to templates.
+const maxHistory = 25
+
+// TemplateOption configures the template conversion plugin.
+type TemplateOption func(*templatePlugin)
+
+// TemplateAllowRunners returns an option that restricts the
+// runners a rendered pipeline may target. The allow function
+// returns the permitted runners for the repository, where each
+// entry is a pipeline type (docker), optionally qualified by the
+// platform os and architecture (docker/linux or docker/linux/arm64).
+// An empty list permits all runners.
+func TemplateAllowRunners(allow func(repo *core.Repository) []string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.allowRunners = allow
+	}
+}
+
+// TemplateMaxInclusionDepth returns an option that limits the
+// number of templates that may be included, directly or through
+// nested templates, when rendering a configuration file.
+func TemplateMaxInclusionDepth(n int) TemplateOption {
+	return func(p *templatePlugin) {
+		p.maxDepth = n
+	}
+}
+
+// TemplateExtensionSearchOrder returns an option that sets the
+// order in which file extensions are searched when a template is
+// referenced by name without a file extension.
+func TemplateExtensionSearchOrder(order []string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.searchOrder = order
+	}
+}
+
+// TemplateFuncs returns an option that resolves the functions
+// available to yaml templates in the namespace. If the resolver
+// returns nil the default functions and helpers are used. The
+// helpers (flag, formatDate, formatNumber, now, randInt and
+// randAlphaNum) are bound to the repository, and are available
+// to a resolved namespace only if the resolved functions include
+// the helper name, in which case the value is ignored.
+func TemplateFuncs(resolve func(namespace string) templating.FuncMap) TemplateOption {
+	return func(p *templatePlugin) {
+		p.funcs = resolve
+	}
+}
+
+// TemplateFileService returns an option that enables template
+// documents to include files from the repository using the file
+// service.
+func TemplateFileService(fileService core.FileService) TemplateOption {
+	return func(p *templatePlugin) {
+		p.fileService = fileService
+	}
+}
+
+// TemplateOrgResolver returns an option that resolves metadata
+// for the organization (e.g. team or owner), which is exposed to
+// templates as org.
+func TemplateOrgResolver(resolve func(namespace string) (map[string]interface{}, error)) TemplateOption {
+	return func(p *templatePlugin) {
+		p.org = resolve
+	}
+}
+
+// TemplateReservedKeys returns an option that prevents rendered
+// pipelines from setting the reserved keys. Nested keys are
+// separated by a period (e.g. clone.disable).
+func TemplateReservedKeys(keys []string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.reservedKeys = keys
+	}
+}
+
+// TemplateRegistryMirror returns an option that rewrites the
+// images of rendered pipeline steps and services to use the
+// registry mirror (e.g. for air-gapped installations). Images in
+// the lookup table are replaced with the mapped image, and all
+// other images are prefixed with the mirror address, unless they
+// already use the mirror.
+func TemplateRegistryMirror(mirror string, table map[string]string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.mirror = strings.TrimSuffix(mirror, "/")
+		p.mirrorTable = table
+	}
+}
+
+// TemplateCache returns an option that caches converted
+// configuration files, up to the given number of entries.
+// Entries expire after the ttl, unless the template document
+// sets a different ttl using the cache directive. Entries cached
+// for longer are checked for changes to the templates once per
+// ttl.
+func TemplateCache(size int, ttl time.Duration) TemplateOption {
+	return func(p *templatePlugin) {
+		p.cache = MemoryCache(size)
+		p.cacheTTL = ttl
+	}
+}
+
+// TemplateCacheBackend returns an option that caches converted
+// configuration files and templates not found using the cache
+// instead of the in-memory default. Caching is enabled using
+// TemplateCache and TemplateNegativeCache, which set the ttl;
+// the size is ignored.
+func TemplateCacheBackend(cache Cache) TemplateOption {
+	return func(p *templatePlugin) {
+		p.backend = cache
+	}
+}
+
+// TemplateYamlOnly returns an option that restricts repositories
+// to yaml templates. If the restricted function returns true for
+// the repository, starlark and jsonnet templates are rejected.
+func TemplateYamlOnly(restricted func(repo *core.Repository) bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.yamlOnly = restricted
+	}
+}
+
+// TemplateRequireName returns an option that checks each
+// rendered pipeline sets an explicit name, since pipelines without
+// a name are assigned a default name that may collide.
+func TemplateRequireName(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.requireName = mode
+	}
+}
+
+// TemplateParamsSchema returns an option that validates the
+// input parameters against the schema returned by the resolver
+// before rendering, in addition to the constraints the template
+// declares in its header. The resolver returns nil if there is
+// no schema for the template.
+func TemplateParamsSchema(resolve func(template *core.Template) *ParamsSchema) TemplateOption {
+	return func(p *templatePlugin) {
+		p.schema = resolve
+	}
+}
+
+// TemplateEmptyConfig returns an option that sets how an empty
+// configuration file is converted. By default the configuration
+// file is skipped.
+func TemplateEmptyConfig(mode EmptyConfigMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.emptyConfig = mode
+	}
+}
+
+// TemplateMaxTotalSteps returns an option that limits the number
+// of steps across all pipelines in the rendered configuration,
+// which bounds the load a single build places on the scheduler.
+func TemplateMaxTotalSteps(n int) TemplateOption {
+	return func(p *templatePlugin) {
+		p.maxTotalSteps = n
+	}
+}
+
+// TemplateFallbackConfig returns an option that sets a static
+// configuration file (e.g. a pipeline that notifies the team) that
+// is returned in place of a failed conversion. The conversion error
+// is reported as a warning in the conversion info.
+func TemplateFallbackConfig(data string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.fallback = data
+	}
+}
+
+// TemplateInputAtTopLevel returns an option that merges the input
+// into the top-level scope of yaml templates, so that input values
+// are accessible as {{ .region }} in addition to {{ .input.region }}.
+func TemplateInputAtTopLevel(enabled bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.inputAtTopLevel = enabled
+	}
+}
+
+// TemplateDuplicateLoads returns an option that checks whether a
+// configuration file loads the same template more than once with
+// identical data. Loading the same template with different data is
+// permitted.
+func TemplateDuplicateLoads(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.duplicateLoads = mode
+	}
+}
+
+// TemplateKnownKinds returns an option that checks each rendered
+// document has a known kind (pipeline, secret, signature or cron).
+func TemplateKnownKinds(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.knownKinds = mode
+	}
+}
+
+// TemplateNameNormalizer returns an option that normalizes the
+// name of the loaded template before it is resolved from the
+// datastore (e.g. replacing underscores with hyphens). Templates
+// must be stored using the normalized name.
+func TemplateNameNormalizer(normalize func(name string) string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.normalize = normalize
+	}
+}
+
+// TemplateNamePattern returns an option that requires the name
+// of each loaded template to match the pattern, enforcing a naming
+// convention. The name is checked after it is normalized, and
+// before it is rewritten and resolved.
+func TemplateNamePattern(pattern *regexp.Regexp) TemplateOption {
+	return func(p *templatePlugin) {
+		p.namePattern = pattern
+	}
+}
+
+// TemplateClock returns an option that sets the clock used by
+// the time functions available to yaml templates (e.g. now), and
+// to expire cached configuration files. The current time is used
+// by default.
+func TemplateClock(now func() time.Time) TemplateOption {
+	return func(p *templatePlugin) {
+		p.now = now
+	}
+}
+
+// TemplateRandSeed returns an option that sets the source of the
+// seed used by the random functions available to yaml templates
+// (e.g. randInt). A fixed seed produces reproducible output, for
+// example when testing templates. A time-based seed is used by
+// default.
+func TemplateRandSeed(seed func() int64) TemplateOption {
+	return func(p *templatePlugin) {
+		p.seed = seed
+	}
+}
+
+// TemplateLoadRewriter returns an option that rewrites the name
+// of the loaded template before it is resolved from the datastore,
+// after the name is normalized. The rewriter is used to migrate or
+// route builds to another template, such as a canary version.
+func TemplateLoadRewriter(rewrite func(ctx context.Context, repo *core.Repository, load string) (string, error)) TemplateOption {
+	return func(p *templatePlugin) {
+		p.rewrite = rewrite
+	}
+}
+
+// TemplateRequiredSecrets returns an option that checks the
+// secrets templates declare they require exist, using the
+// function to look up a secret by name. Required secrets are
+// reported in the conversion info whether or not they are
+// checked.
+func TemplateRequiredSecrets(mode CheckMode, exists func(ctx context.Context, repo *core.Repository, name string) (bool, error)) TemplateOption {
+	return func(p *templatePlugin) {
+		p.requiredSecrets = mode
+		p.secretExists = exists
+	}
+}
+
+// TemplateEngineHints returns an option that selects the engine
+// declared on the first line of a template (e.g. // jsonnet) in
+// place of the engine matching the file extension. Templates
+// without a hint use the file extension.
+func TemplateEngineHints(enabled bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.engineHints = enabled
+	}
+}
+
+// TemplateLinter returns an option that lints the rendered
+// configuration file, failing the conversion with LintErrors
+// if any lint rule fails. Use DroneLinter to apply the lint
+// rules applied by Drone when the build is created.
+func TemplateLinter(lint LintFunc) TemplateOption {
+	return func(p *templatePlugin) {
+		p.lint = lint
+	}
+}
+
+// TemplateNegativeCache returns an option that caches templates
+// not found in the datastore for the duration of the ttl. Because
+// templates are created using a different store, the cached result
+// may be stale until the ttl expires; use NegativeCache to wrap the
+// shared store instead, which is invalidated when templates are
+// created.
+func TemplateNegativeCache(size int, ttl time.Duration) TemplateOption {
+	return func(p *templatePlugin) {
+		p.negativeCacheSize = size
+		p.negativeCacheTTL = ttl
+	}
+}
+
+// TemplatePrettyPrint returns an option that re-indents the json
+// output of jsonnet and starlark templates using two spaces, so
+// the rendered configuration is readable in the user interface.
+// The output of yaml templates is not changed.
+func TemplatePrettyPrint(enabled bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.prettyPrint = enabled
+	}
+}
+
+// TemplateEmitProvenance returns an option that appends a
+// provenance record (kind: provenance) to the configuration
+// file, listing each template used with its version, checksum,
+// engine and render time. The record is intended for auditors,
+// and is written as a yaml comment so that it is not parsed as
+// a pipeline.
+func TemplateEmitProvenance(enabled bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.provenance = enabled
+	}
+}
+
+// TemplateWithStoreRetry returns an option that retries finding
+// a template when the datastore fails with a timeout, up to the
+// number of attempts. The backoff doubles after each attempt,
+// and retries stop when the context is done.
+func TemplateWithStoreRetry(attempts int, backoff time.Duration) TemplateOption {
+	return func(p *templatePlugin) {
+		if attempts > 1 {
+			p.templateStore = retryStore(p.templateStore, attempts, backoff)
+		}
+	}
+}
+
+// TemplateMaxDataSize returns an option that limits the size, in
+// bytes, of the serialized data block of a template document. The
+// limit is checked before the template is rendered.
+func TemplateMaxDataSize(size int) TemplateOption {
+	return func(p *templatePlugin) {
+		p.maxDataSize = size
+	}
+}
+
+// TemplateDocumentTransform returns an option that transforms
+// each document of the configuration file before it is validated.
+// The transform receives a flag that is true if the document was
+// rendered by a template, and false if the document was passed
+// through from the configuration file, so that transforms can be
+// scoped to rendered documents.
+func TemplateDocumentTransform(transform func(ctx context.Context, repo *core.Repository, doc string, rendered bool) (string, error)) TemplateOption {
+	return func(p *templatePlugin) {
+		p.docTransform = transform
+	}
+}
+
+// TemplateMaxNestingDepth returns an option that limits the
+// nesting depth of rendered documents, where each map or list
+// adds a level.
+func TemplateMaxNestingDepth(n int) TemplateOption {
+	return func(p *templatePlugin) {
+		p.maxNesting = n
+	}
+}
+
+// TemplateMaxComplexity returns an option that limits the static
+// complexity of starlark and jsonnet templates, which is computed
+// from the syntax tree before the template is executed. Nodes in
+// nested starlark loops are weighted, see starlarkComplexity.
+func TemplateMaxComplexity(n int) TemplateOption {
+	return func(p *templatePlugin) {
+		p.maxComplexity = n
+	}
+}
+
+// TemplateRequireTemplate returns an option that requires every
+// yaml configuration file to be a template, returning an error if
+// the configuration file is not a template.
+func TemplateRequireTemplate(required bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.requireTemplate = required
+	}
+}
+
+// TemplateFeatureFlags returns an option that exposes feature
+// flags to yaml templates using the flag function, for example
+// {{ if flag "new-deploy" }}. The resolver returns the function
+// reporting whether a flag is enabled for the repository.
+func TemplateFeatureFlags(resolve func(repo *core.Repository) func(flag string) bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.flags = resolve
+	}
+}
+
+// TemplateCanonicalize returns an option that re-encodes the
+// rendered configuration in canonical form, so that the output
+// is stable for caching and diffs. Map keys either retain the
+// order of the rendered output or are sorted.
+func TemplateCanonicalize(order KeyOrder) TemplateOption {
+	return func(p *templatePlugin) {
+		p.keyOrder = order
+	}
+}
+
+// TemplateEnabledEngines returns an option that sets the engines
+// enabled server-wide (yaml, starlark or jsonnet). Templates that
+// use a disabled engine are rejected. All engines are enabled if
+// the list is empty.
+func TemplateEnabledEngines(engines []string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.engines = engines
+	}
+}
+
+// TemplateLocale returns an option that sets the locale used by
+// the formatDate and formatNumber functions available to yaml
+// templates (e.g. de-DE). The default locale is en-US, and
+// yaml templates fail to render if the locale is not supported.
+func TemplateLocale(locale string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.locale = locale
+	}
+}
+
+// TemplateCollapseBlankLines returns an option that collapses
+// runs of blank lines in the rendered configuration to a single
+// blank line.
+func TemplateCollapseBlankLines(enabled bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.collapseBlankLines = enabled
+	}
+}
+
+// TemplatePinnedImages returns an option that checks each
+// rendered pipeline step and service image is pinned to a digest
+// (e.g. golang@sha256:...).
+func TemplatePinnedImages(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.pinnedImages = mode
+	}
+}
+
+// TemplateForbiddenCommands returns an option that checks the
+// commands of rendered pipeline steps and services do not match
+// any of the forbidden patterns (e.g. piping a download to a
+// shell).
+func TemplateForbiddenCommands(mode CheckMode, patterns []*regexp.Regexp) TemplateOption {
+	return func(p *templatePlugin) {
+		p.forbiddenCommands = mode
+		p.commandPatterns = patterns
+	}
+}
+
+// TemplateDedupDocuments returns an option that removes rendered
+// documents that are byte-identical to a previous document, such
+// as a pipeline accidentally rendered twice. The option is
+// disabled by default, since duplicate documents may be
+// intentional.
+func TemplateDedupDocuments(enabled bool) TemplateOption {
+	return func(p *templatePlugin) {
+		p.dedup = enabled
+	}
+}
+
+// TemplateServer returns an option that exposes the server url and
+// version to templates under the server scope (e.g. server.url),
+// which templates use to build links to the server. The values
+// are empty by default.
+func TemplateServer(url, version string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.serverURL = strings.TrimSuffix(url, "/")
+		p.serverVersion = version
+	}
+}
+
+// TemplateValues returns an option that exposes the shared values
+// of the repository namespace to templates under the values scope
+// (e.g. values.registry). The values are parsed from the named
+// yaml template (e.g. values.yaml) in the namespace, and are empty
+// if the namespace does not have a values template.
+func TemplateValues(name string) TemplateOption {
+	return func(p *templatePlugin) {
+		p.values = name
+	}
+}
+
+// TemplateSeparatorStyle returns an option that rewrites the
+// document separators of the converted configuration file using
+// a consistent style, as required by some downstream tools.
+func TemplateSeparatorStyle(style SeparatorStyle) TemplateOption {
+	return func(p *templatePlugin) {
+		p.separators = style
+	}
+}
+
+// TemplateDuplicateKeys returns an option that checks rendered
+// documents do not set the same key more than once in a map, which
+// the yaml parser otherwise silently resolves using the last value.
+func TemplateDuplicateKeys(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.duplicateKeys = mode
+	}
+}
+
+// TemplateTabs returns an option that checks rendered documents
+// are not indented with tabs, which yaml does not allow and which
+// the yaml decoder otherwise reports as a confusing syntax error.
+func TemplateTabs(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.tabs = mode
+	}
+}
+
+// TemplateStarlarkTrace returns an option that records a trace
+// of the builtin functions called by starlark templates, up to the
+// given number of entries, in the conversion info. Tracing is for
+// debugging and is disabled if the limit is zero.
+func TemplateStarlarkTrace(limit int) TemplateOption {
+	return func(p *templatePlugin) {
+		p.traceLimit = limit
+	}
+}
+
+// TemplateUnknownFields returns an option that checks rendered
+// pipelines only set known top-level pipeline fields, which
+// catches typos in templates.
+func TemplateUnknownFields(mode CheckMode) TemplateOption {
+	return func(p *templatePlugin) {
+		p.unknownFields = mode
+	}
+}
+
+// Template returns a conversion service that renders template
+// documents. It is a shorthand for TemplateWithConfig with the
+// step and size limits.
+func Template(templateStore core.TemplateStore, stepLimit uint64, sizeLimit uint64, opts ...TemplateOption) core.ConvertService {
+	config := TemplateConfig{
+		StepLimit: stepLimit,
+		SizeLimit: sizeLimit,
+	}
+	return TemplateWithConfig(templateStore, config, opts...)
+}
+
+// TemplateWithConfig returns a conversion service that renders
+// template documents using the configuration. The options are
+// applied after the configuration.
+func TemplateWithConfig(templateStore core.TemplateStore, config TemplateConfig, opts ...TemplateOption) core.ConvertService {
+	p := &templatePlugin{
+		templateStore:      templateStore,
+		stepLimit:          config.StepLimit,
+		sizeLimit:          config.SizeLimit,
+		maxDepth:           config.MaxInclusionDepth,
+		maxTotalSteps:      config.MaxTotalSteps,
+		maxNesting:         config.MaxNestingDepth,
+		maxComplexity:      config.MaxComplexity,
+		maxDataSize:        config.MaxDataSize,
+		searchOrder:        config.ExtensionSearchOrder,
+		engines:            config.EnabledEngines,
+		fileService:        config.FileService,
+		reservedKeys:       config.ReservedKeys,
+		mirror:             strings.TrimSuffix(config.RegistryMirror, "/"),
+		mirrorTable:        config.RegistryMirrorTable,
+		namePattern:        config.NamePattern,
+		cacheTTL:           config.CacheTTL,
+		negativeCacheSize:  config.NegativeCacheSize,
+		negativeCacheTTL:   config.NegativeCacheTTL,
+		backend:            config.Cache,
+		requireName:        config.RequireName,
+		duplicateLoads:     config.DuplicateLoads,
+		duplicateKeys:      config.DuplicateKeys,
+		tabs:               config.Tabs,
+		requiredSecrets:    config.RequiredSecrets,
+		knownKinds:         config.KnownKinds,
+		pinnedImages:       config.PinnedImages,
+		forbiddenCommands:  config.ForbiddenCommands,
+		commandPatterns:    config.CommandPatterns,
+		unknownFields:      config.UnknownFields,
+		keyOrder:           config.Canonicalize,
+		separators:         config.SeparatorStyle,
+		emptyConfig:        config.EmptyConfig,
+		fallback:           config.FallbackConfig,
+		locale:             config.Locale,
+		values:             config.ValuesTemplate,
+		serverURL:          strings.TrimSuffix(config.ServerURL, "/"),
+		serverVersion:      config.ServerVersion,
+		inputAtTopLevel:    config.InputAtTopLevel,
+		requireTemplate:    config.RequireTemplate,
+		collapseBlankLines: config.CollapseBlankLines,
+		engineHints:        config.EngineHints,
+		prettyPrint:        config.PrettyPrint,
+		provenance:         config.EmitProvenance,
+		dedup:              config.DedupDocuments,
+		traceLimit:         config.StarlarkTraceLimit,
+		now:                time.Now,
+		seed:               config.RandSeed,
+		allowRunners:       config.AllowRunners,
+		funcs:              config.Funcs,
+		org:                config.OrgResolver,
+		yamlOnly:           config.YamlOnly,
+		schema:             config.ParamsSchema,
+		normalize:          config.NameNormalizer,
+		rewrite:            config.LoadRewriter,
+		secretExists:       config.SecretExists,
+		lint:               config.Linter,
+		docTransform:       config.DocumentTransform,
+		flags:              config.FeatureFlags,
+	}
+	if config.Clock != nil {
+		p.now = config.Clock
+	}
+	if config.CacheSize > 0 {
+		p.cache = MemoryCache(config.CacheSize)
+	}
+	if config.StoreRetryAttempts > 1 {
+		p.templateStore = retryStore(p.templateStore, config.StoreRetryAttempts, config.StoreRetryBackoff)
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	// the cache backend replaces the in-memory caches, and
+	// is applied after the options so the order of the cache
+	// options does not matter.
+	if p.cache != nil && p.backend != nil {
+		p.cache = p.backend
+	}
+	if p.negativeCacheSize > 0 {
+		cache := p.backend
+		if cache == nil {
+			cache = MemoryCache(p.negativeCacheSize)
+		}
+		p.templateStore = newNegativeCache(p.templateStore, cache, p.negativeCacheTTL)
+	}
+	return p
+}
+
+type templatePlugin struct {
+	templateStore      core.TemplateStore
+	stepLimit          uint64
+	sizeLimit          uint64
+	maxDepth           int
+	maxTotalSteps      int
+	maxNesting         int
+	maxComplexity      int
+	maxDataSize        int
+	searchOrder        []string
+	engines            []string
+	fileService        core.FileService
+	reservedKeys       []string
+	mirror             string
+	mirrorTable        map[string]string
+	namePattern        *regexp.Regexp
+	cache              Cache
+	cacheTTL           time.Duration
+	backend            Cache
+	negativeCacheSize  int
+	negativeCacheTTL   time.Duration
+	requireName        CheckMode
+	duplicateLoads     CheckMode
+	duplicateKeys      CheckMode
+	tabs               CheckMode
+	requiredSecrets    CheckMode
+	knownKinds         CheckMode
+	pinnedImages       CheckMode
+	forbiddenCommands  CheckMode
+	commandPatterns    []*regexp.Regexp
+	unknownFields      CheckMode
+	keyOrder           KeyOrder
+	separators         SeparatorStyle
+	emptyConfig        EmptyConfigMode
+	fallback           string
+	locale             string
+	values             string
+	serverURL          string
+	serverVersion      string
+	inputAtTopLevel    bool
+	requireTemplate    bool
+	collapseBlankLines bool
+	engineHints        bool
+	prettyPrint        bool
+	provenance         bool
+	dedup              bool
+	traceLimit         int
+	now                func() time.Time
+
+	// allowRunners returns the runners a rendered pipeline
+	// is permitted to target for the given repository.
+	allowRunners func(repo *core.Repository) []string
+
+	// funcs returns the functions available to yaml
+	// templates in the given namespace.
+	funcs func(namespace string) templating.FuncMap
+
+	// org returns the organization metadata for the
+	// given namespace.
+	org func(namespace string) (map[string]interface{}, error)
+
+	// yamlOnly returns true if the repository is
+	// restricted to yaml templates.
+	yamlOnly func(repo *core.Repository) bool
+
+	// schema returns the input parameters schema
+	// declared by the template.
+	schema func(template *core.Template) *ParamsSchema
+
+	// normalize returns the normalized template name.
+	normalize func(name string) string
+
+	// rewrite returns the rewritten template name.
+	rewrite func(ctx context.Context, repo *core.Repository, load string) (string, error)
+
+	// secretExists returns true if the named secret
+	// exists for the given repository.
+	secretExists func(ctx context.Context, repo *core.Repository, name string) (bool, error)
+
+	// lint returns the lint rules failed by the rendered
+	// configuration file.
+	lint LintFunc
+
+	// docTransform returns the transformed document.
+	docTransform func(ctx context.Context, repo *core.Repository, doc string, rendered bool) (string, error)
+
+	// flags returns the function reporting whether a
+	// feature flag is enabled for the given repository.
+	flags func(repo *core.Repository) func(flag string) bool
+
+	// seed returns the seed of the random functions
+	// available to yaml templates.
+	seed func() int64
+}
+
+func (p *templatePlugin) Convert(ctx context.Context, req *core.ConvertArgs) (*core.Config, error) {
+	config, _, err := p.ConvertWithInfo(ctx, req)
+	return config, err
+}
+
+func (p *templatePlugin) ConvertWithInfo(ctx context.Context, req *core.ConvertArgs) (*core.Config, *ConvertInfo, error) {
+	config, info, err := p.convert(ctx, req)
+	if err != nil && p.fallback != "" {
+		return p.convertFallback(req, err)
+	}
+	return config, info, err
+}
+
+// convertFallback returns the fallback configuration file in
+// place of a failed conversion. The conversion error is logged
+// and added to the conversion info as a warning.
+func (p *templatePlugin) convertFallback(req *core.ConvertArgs, err error) (*core.Config, *ConvertInfo, error) {
+	logrus.WithError(err).
+		WithField("repo", req.Repo.Slug).
+		Warnln("template converter: conversion failed, using fallback configuration")
+
+	checksum := sha256.Sum256([]byte(p.fallback))
+	info := &ConvertInfo{
+		Checksum: hex.EncodeToString(checksum[:]),
+		Warnings: []string{err.Error()},
+	}
+	return &core.Config{Data: p.fallback}, info, nil
+}
+
+func (p *templatePlugin) convert(ctx context.Context, req *core.ConvertArgs) (*core.Config, *ConvertInfo, error) {
+	// check type is yaml
+	configExt := filepath.Ext(req.Repo.Config)
+
+	if configExt != ".yml" && configExt != ".yaml" {
+		return nil, nil, nil
+	}
+
+	// check if the configuration file is empty
+	if strings.TrimSpace(req.Config.Data) == "" {
+		return p.convertEmpty()
+	}
+
+	// check kind is template
+	if isTemplate(req.Config.Data) == false {
+		if p.requireTemplate {
+			return nil, nil, userError(errTemplateRequired)
+		}
+		return nil, nil, nil
+	}
+
+	scope, err := p.scope(ctx, req)
+	if err != nil {
+		return nil, nil, userError(err)
+	}
+
+	// check the cache for the converted configuration
+	// file and return if exists.
+	var key string
+	if p.cache != nil {
+		key = cacheKey(req, scope, p.limits(ctx, req))
+		if config, info, ok := p.cached(ctx, key); ok {
+			return config, info, nil
+		}
+	}
+
+	// errors that are not the result of a datastore
+	// failure are caused by the configuration file or
+	// template, and are reported as user errors.
+	info := new(ConvertInfo)
+	config, err := p.renderDocuments(ctx, req, scope, info)
+	if err != nil {
+		return nil, nil, userError(err)
+	}
+	// documents rendered more than once may be removed
+	// before the configuration is validated.
+	if p.dedup {
+		if data, removed := dedupDocuments(config.Data); removed != 0 {
+			logrus.WithField("repo", req.Repo.Slug).
+				WithField("removed", removed).
+				Infoln("template converter: removed duplicate documents")
+			config.Data = data
+		}
+	}
+
+	// the template may emit a skip document to indicate
+	// there is nothing to build, in which case the build
+	// is skipped without validating the configuration.
+	if skipDocument(config.Data) {
+		config.Kind = core.ConfigKindSkip
+		info.Skip = true
+	} else {
+		if err := p.validate(req, config, info); err != nil {
+			return nil, nil, userError(err)
+		}
+		if err := p.transform(config); err != nil {
+			return nil, nil, userError(err)
+		}
+		if p.lint != nil {
+			if errs := p.lint(ctx, req.Repo, config.Data); len(errs) != 0 {
+				return nil, nil, userError(LintErrors(errs))
+			}
+		}
+		if p.provenance {
+			config.Data = appendProvenance(config.Data, info)
+		}
+	}
+	if p.collapseBlankLines {
+		config.Data = collapseBlankLines(config.Data)
+	}
+	config.Data = applySeparators(config.Data, p.separators)
+
+	checksum := sha256.Sum256([]byte(config.Data))
+	info.Checksum = hex.EncodeToString(checksum[:])
+
+	if p.cache != nil {
+		p.store(ctx, key, req, config, info)
+	}
+	return config, info, nil
+}
+
+// convertEmpty converts an empty configuration file according
+// to the empty configuration mode.
+func (p *templatePlugin) convertEmpty() (*core.Config, *ConvertInfo, error) {
+	switch p.emptyConfig {
+	case EmptyConfigError:
+		return nil, nil, userError(errTemplateEmptyConfig)
+	case EmptyConfigDefault:
+		checksum := sha256.Sum256([]byte(defaultEmptyConfig))
+		info := &ConvertInfo{Checksum: hex.EncodeToString(checksum[:])}
+		return &core.Config{Data: defaultEmptyConfig}, info, nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// isTemplate returns true if the configuration file declares
+// kind template. It is equivalent to matching templateFileRE, but
+// avoids the cost of evaluating the regular expression against
+// each line of large configuration files.
+func isTemplate(data string) bool {
+	for offset := 0; offset < len(data); {
+		i := strings.Index(data[offset:], "kind:")
+		if i == -1 {
+			return false
+		}
+		start := offset + i
+		offset = start + len("kind:")
+
+		// the kind must be at the start of a line.
+		if start != 0 && data[start-1] != '\n' {
+			continue
+		}
+
+		// the kind must be followed by whitespace, the
+		// template marker, and optional whitespace up to
+		// the end of the line.
+		rest := data[offset:]
+		trimmed := strings.TrimLeft(rest, posixSpace)
+		if len(trimmed) == len(rest) || !strings.HasPrefix(trimmed, "template") {
+			continue
+		}
+		trimmed = trimmed[len("template"):]
+		tail := strings.TrimLeft(trimmed, posixSpace)
+		if len(tail) == 0 || strings.ContainsRune(trimmed[:len(trimmed)-len(tail)], '\n') {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKey returns the key used to cache the converted
+// configuration file. The key is a checksum of the inputs exposed
+// to templates, including the build, repository, history, user and
+// scope, so that a change to any input results in a different key.
+// The effective limits are included, so that a configuration file
+// converted using raised limits is not returned to conversions held
+// to the default limits. Changes to the templates are detected when
+// the entry is read.
+func cacheKey(req *core.ConvertArgs, scope map[string]interface{}, limits Limits) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v|%v|%v|%d|%d|",
+		templateBuild(req.Build, req.History),
+		templateRepo(req.Repo),
+		scope,
+		limits.StepLimit,
+		limits.SizeLimit,
+	)
+	if req.User != nil {
+		fmt.Fprintf(h, "%d|%s|", req.User.ID, req.User.Login)
+	}
+	io.WriteString(h, req.Config.Data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cached returns the converted configuration file from the
+// cache if it exists and has not expired.
+func (p *templatePlugin) cached(ctx context.Context, key string) (*core.Config, *ConvertInfo, bool) {
+	v, ok := p.cache.Get(ctx, key)
+	if !ok {
+		return nil, nil, false
+	}
+	entry := new(templateCacheEntry)
+	if err := json.Unmarshal(v, entry); err != nil {
+		p.cache.Delete(ctx, key)
+		return nil, nil, false
+	}
+	now := p.now()
+	if !now.Before(entry.Expires) {
+		p.cache.Delete(ctx, key)
+		return nil, nil, false
+	}
+
+	// the templates are checked for changes at most once per
+	// default ttl, which bounds the time a changed template is
+	// not reflected in entries cached for longer using the
+	// cache directive.
+	if p.cacheTTL > 0 && !now.Before(entry.Checked.Add(p.cacheTTL)) {
+		if !p.unchanged(ctx, entry.Templates) {
+			p.cache.Delete(ctx, key)
+			return nil, nil, false
+		}
+		entry.Checked = now
+		if data, err := json.Marshal(entry); err == nil {
+			p.cache.Set(ctx, key, data, entry.Expires.Sub(now))
+		}
+	}
+	return &entry.Config, &entry.Info, true
+}
+
+// unchanged returns true if the templates used to render a
+// cached configuration file are unchanged in the datastore.
+func (p *templatePlugin) unchanged(ctx context.Context, templates []TemplateUsage) bool {
+	for _, usage := range templates {
+		template, err := p.templateStore.FindName(ctx, usage.Name, usage.Namespace)
+		if err != nil || template == nil {
+			return false
+		}
+		checksum := sha256.Sum256([]byte(template.Data))
+		if hex.EncodeToString(checksum[:]) != usage.Checksum {
+			return false
+		}
+	}
+	return true
+}
+
+// store adds the converted configuration file to the cache.
+// The entry expires after the ttl set by the cache directive of
+// the template document, or the default ttl if unset.
+func (p *templatePlugin) store(ctx context.Context, key string, req *core.ConvertArgs, config *core.Config, info *ConvertInfo) {
+	ttl := p.cacheTTL
+	var templateArgs core.TemplateArgs
+	if err := yaml.Unmarshal([]byte(req.Config.Data), &templateArgs); err == nil && templateArgs.Cache.TTL > 0 {
+		ttl = templateArgs.Cache.TTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := p.now()
+	data, err := json.Marshal(&templateCacheEntry{
+		Config:    *config,
+		Info:      *info,
+		Templates: info.stored,
+		Expires:   now.Add(ttl),
+		Checked:   now,
+	})
+	if err != nil {
+		return
+	}
+	p.cache.Set(ctx, key, data, ttl)
+}
+
+// Preview converts the configuration file and applies the preview
+// options to the converted configuration.
+func (p *templatePlugin) Preview(ctx context.Context, req *core.ConvertArgs, opts ...PreviewOption) (*core.Config, error) {
+	config, _, err := p.ConvertWithInfo(ctx, req)
+	if err != nil || config == nil {
+		return config, err
+	}
+	options := new(previewOptions)
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.maxSteps > 0 {
+		data, err := truncateSteps(config.Data, options.maxSteps)
+		if err != nil {
+			return nil, userError(err)
+		}
+		config = &core.Config{Kind: config.Kind, Data: data}
+	}
+	return config, nil
+}
+
+// Diff converts both configuration files and returns a unified
+// diff of the converted configurations. If a configuration file
+// is not a template, the configuration file is compared as-is.
+func (p *templatePlugin) Diff(ctx context.Context, before, after *core.ConvertArgs) (string, error) {
+	a, err := p.Convert(ctx, before)
+	if err != nil {
+		return "", err
+	}
+	if a == nil {
+		a = before.Config
+	}
+	b, err := p.Convert(ctx, after)
+	if err != nil {
+		return "", err
+	}
+	if b == nil {
+		b = after.Config
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a.Data),
+		B:        splitLines(b.Data),
+		FromFile: "before",
+		ToFile:   "after",
+		Context:  3,
+	})
+}
+
+// RenderByName renders the named template with the input data,
+// without a configuration file. The conversion arguments provide
+// the repository and build exposed to the template, and may be
+// nil.
+func (p *templatePlugin) RenderByName(ctx context.Context, name, namespace string, data map[string]interface{}, req *core.ConvertArgs) (string, error) {
+	if req == nil {
+		req = new(core.ConvertArgs)
+	}
+	args := *req
+	if args.Repo == nil {
+		args.Repo = &core.Repository{Namespace: namespace}
+	}
+	if args.Config == nil {
+		args.Config = new(core.Config)
+	}
+
+	template, err := p.find(ctx, name, namespace)
+	if err != nil {
+		return "", userError(err)
+	}
+	scope, err := p.scope(ctx, &args)
+	if err != nil {
+		return "", userError(err)
+	}
+	if err := p.checkDataSize(data); err != nil {
+		return "", userError(err)
+	}
+	if err := p.checkParams(template, data); err != nil {
+		return "", userError(err)
+	}
+	templateArgs := core.TemplateArgs{
+		Kind: "template",
+		Load: template.Name,
+		Data: data,
+	}
+	config, err := p.parseTemplate(ctx, &args, template, templateArgs, scope, new(ConvertInfo))
+	if err != nil {
+		return "", userError(err)
+	}
+	return config.Data, nil
+}
+
+// blockScalarRE matches a line that introduces a yaml block
+// scalar (e.g. commands: | or - >-).
+var blockScalarRE = regexp.MustCompile(`(?:^|:|-)[ \t]*[|>][-+0-9]*[ \t]*(?:#.*)?$`)
+
+// collapseBlankLines collapses runs of blank lines in the yaml
+// configuration to a single blank line. Blank lines within block
+// scalars are retained, since they are part of the value.
+func collapseBlankLines(data string) string {
+	lines := strings.Split(data, "\n")
+	out := make([]string, 0, len(lines))
+	block := -1 // indentation of the line introducing a block scalar
+	blank := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if block != -1 {
+			if trimmed == "" || indent > block {
+				out = append(out, line)
+				continue
+			}
+			block = -1
+		}
+		if trimmed == "" {
+			if !blank {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		out = append(out, line)
+		if blockScalarRE.MatchString(line) {
+			block = indent
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// scope returns the additional values exposed to templates
+// alongside the repository, build and input.
+func (p *templatePlugin) scope(ctx context.Context, req *core.ConvertArgs) (map[string]interface{}, error) {
+	scope := map[string]interface{}{
+		"server": map[string]interface{}{
+			"url":     p.serverURL,
+			"version": p.serverVersion,
+		},
+	}
+	if p.org != nil {
+		org, err := p.org(req.Repo.Namespace)
+		if err != nil {
+			return nil, &ServerError{
+				Err: fmt.Errorf("template converter: cannot resolve organization metadata for namespace %q: %w", req.Repo.Namespace, err),
+			}
+		}
+		if org == nil {
+			org = map[string]interface{}{}
+		}
+		scope["org"] = org
+	}
+	if p.values != "" {
+		values, err := p.findValues(ctx, req.Repo.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		scope["values"] = values
+	}
+	return scope, nil
+}
+
+// findValues returns the shared values of the namespace, parsed
+// from the values template. The values are empty if the namespace
+// does not have a values template.
+func (p *templatePlugin) findValues(ctx context.Context, namespace string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	template, err := p.templateStore.FindName(ctx, p.values, namespace)
+	if err == sql.ErrNoRows || (err == nil && template == nil) {
+		return values, nil
+	}
+	if err != nil {
+		return nil, &ServerError{Err: err}
+	}
+	if err := yaml.Unmarshal([]byte(template.Data), &values); err != nil {
+		return nil, fmt.Errorf("template converter: invalid values template %q: %w", p.values, err)
+	}
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
+
+// renderDocuments renders the template documents in the
+// configuration file. If the configuration file contains multiple
+// documents, each template document is rendered and documents
+// that are not template documents are passed through unchanged.
+func (p *templatePlugin) renderDocuments(ctx context.Context, req *core.ConvertArgs, scope map[string]interface{}, info *ConvertInfo) (*core.Config, error) {
+	docs := splitDocuments(req.Config.Data)
+	if len(docs) <= 1 {
+		config, err := p.render(ctx, req, req.Config.Data, nil, scope, info)
+		if err != nil {
+			return nil, err
+		}
+		config.Data, err = p.transformDocuments(ctx, req, config.Data, true)
+		if err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+
+	var buf strings.Builder
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		if !isTemplate(doc) {
+			doc, err := p.transformDocuments(ctx, req, doc, false)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString("---\n")
+			buf.WriteString(doc)
+			continue
+		}
+
+		// loading the same template with identical data
+		// more than once is usually a mistake.
+		if p.duplicateLoads != CheckOff {
+			if name, key, ok := loadKey(doc); ok {
+				if seen[key] {
+					err := fmt.Errorf("template converter: template %q is loaded more than once with identical data", name)
+					if err := report(req, info, p.duplicateLoads, err); err != nil {
+						return nil, err
+					}
+				}
+				seen[key] = true
+			}
+		}
+
+		config, err := p.render(ctx, req, doc, nil, scope, info)
+		if err != nil {
+			return nil, err
+		}
+		config.Data, err = p.transformDocuments(ctx, req, config.Data, true)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(config.Data, "---") {
+			buf.WriteString("---\n")
+		}
+		buf.WriteString(config.Data)
+		if !strings.HasSuffix(config.Data, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return &core.Config{Data: buf.String()}, nil
+}
+
+// transformDocuments applies the document transform to each
+// document in the data, where rendered is true if the documents
+// were rendered by a template, and false if the documents were
+// passed through from the configuration file.
+func (p *templatePlugin) transformDocuments(ctx context.Context, req *core.ConvertArgs, data string, rendered bool) (string, error) {
+	if p.docTransform == nil {
+		return data, nil
+	}
+	var buf strings.Builder
+	for i, doc := range splitDocuments(data) {
+		doc, err := p.docTransform(ctx, req.Repo, doc, rendered)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.WriteString(doc)
+		if !strings.HasSuffix(doc, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String(), nil
+}
+
+// loadKey returns the name of the template loaded by the
+// template document, and a key combining the name and canonical
+// input data, used to detect duplicate loads.
+func loadKey(doc string) (name, key string, ok bool) {
+	var templateArgs core.TemplateArgs
+	if err := yaml.Unmarshal([]byte(doc), &templateArgs); err != nil {
+		return "", "", false
+	}
+	name = templateArgs.Load
+	if templateArgs.Include != "" {
+		name = templateArgs.Include
+	}
+	data, err := yaml.Marshal(templateArgs.Data)
+	if err != nil {
+		return "", "", false
+	}
+	return name, name + "|" + string(data), true
+}
+
+// render renders the template document. If the rendered
+// output is itself a template document it is rendered in turn,
+// up to the maximum inclusion depth. The chain lists the names
+// of the templates being rendered and is used to detect cycles.
+// The engines used to render templates are added to the info.
+func (p *templatePlugin) render(ctx context.Context, req *core.ConvertArgs, data string, chain []string, scope map[string]interface{}, info *ConvertInfo) (*core.Config, error) {
+	// map to templateArgs
+	var templateArgs core.TemplateArgs
+	err := yaml.Unmarshal([]byte(data), &templateArgs)
+	if err != nil {
+		if err := checkTabs(data); err != nil {
+			return nil, err
+		}
+		return nil, errTemplateSyntaxErrors
+	}
+
+	// the size of the input data is limited to protect
+	// against excessively large inline inputs.
+	if err := p.checkDataSize(templateArgs.Data); err != nil {
+		return nil, err
+	}
+
+	// the template name may be normalized before resolution,
+	// so that minor naming variations (e.g. my_base and my-base)
+	// resolve to the same template.
+	if p.normalize != nil && templateArgs.Load != "" {
+		templateArgs.Load = p.normalize(templateArgs.Load)
+	}
+
+	// the template name may be required to follow a naming
+	// convention.
+	if p.namePattern != nil && templateArgs.Load != "" && !p.namePattern.MatchString(templateArgs.Load) {
+		return nil, fmt.Errorf("template converter: template name %q does not match the required pattern %q", templateArgs.Load, p.namePattern.String())
+	}
+
+	// the template name may be rewritten before resolution,
+	// for example to route a share of builds to a canary
+	// version of the template.
+	if p.rewrite != nil && templateArgs.Load != "" {
+		load, err := p.rewrite(ctx, req.Repo, templateArgs.Load)
+		if err != nil {
+			return nil, &ServerError{
+				Err: fmt.Errorf("template converter: cannot rewrite template %q: %w", templateArgs.Load, err),
+			}
+		}
+		templateArgs.Load = load
+	}
+
+	// the template document may include a file from the
+	// repository in place of a template from the datastore.
+	name := templateArgs.Load
+	if templateArgs.Include != "" {
+		name, err = includePath(req.Repo.Config, templateArgs.Include)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the folder of the including template, if any, used
+	// to resolve template names relative to the folder.
+	var folder string
+	if len(chain) != 0 {
+		folder = path.Dir(chain[len(chain)-1])
+	}
+
+	chain, err = p.include(chain, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var template *core.Template
+	if templateArgs.Include != "" {
+		// get template from the repository
+		template, err = p.findFile(ctx, req, name)
+	} else {
+		// get template from db
+		template, err = p.findRelative(ctx, name, folder, req.Repo.Namespace)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the template name may have been resolved from
+	// a name without a file extension, or included from
+	// the repository, in which case the engine is selected
+	// using the resolved name.
+	if (filepath.Ext(templateArgs.Load) == "" || templateArgs.Include != "") && template != nil {
+		templateArgs.Load = template.Name
+	}
+
+	// the input parameters are validated against the
+	// template schema before rendering.
+	if err := p.checkParams(template, templateArgs.Data); err != nil {
+		return nil, err
+	}
+
+	config, err := p.parseTemplate(ctx, req, template, templateArgs, scope, info)
+	if err != nil {
+		return nil, err
+	}
+	engine := p.engine(template, templateArgs.Load)
+	info.addEngine(engine)
+	checksum := sha256.Sum256([]byte(template.Data))
+	usage := TemplateUsage{
+		Name:      template.Name,
+		Namespace: template.Namespace,
+		Version:   template.Updated,
+		Checksum:  hex.EncodeToString(checksum[:]),
+		Engine:    engine,
+		Rendered:  p.now(),
+	}
+	info.Templates = append(info.Templates, usage)
+	if templateArgs.Include == "" {
+		info.stored = append(info.stored, usage)
+	}
+
+	// the template document may declare the secrets the
+	// template requires, which are reported so that users
+	// can be prompted to create them.
+	if err := p.checkSecrets(ctx, req, info, templateArgs.RequiresSecrets); err != nil {
+		return nil, err
+	}
+
+	// the template may render a reference to another
+	// template, in which case the referenced template
+	// is rendered using the output as input.
+	if isTemplate(config.Data) {
+		config, err = p.render(ctx, req, config.Data, chain, scope, info)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the template document may declare the kind of the
+	// documents the template produces.
+	if templateArgs.Produces != "" {
+		if err := checkProduces(config.Data, name, templateArgs.Produces); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// checkDataSize returns an error if the serialized size of the
+// input data exceeds the maximum size.
+func (p *templatePlugin) checkDataSize(data map[string]interface{}) error {
+	if p.maxDataSize <= 0 || len(data) == 0 {
+		return nil
+	}
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if len(out) > p.maxDataSize {
+		return fmt.Errorf("template converter: data block is %d bytes, exceeding the maximum of %d bytes", len(out), p.maxDataSize)
+	}
+	return nil
+}
+
+// checkSecrets adds the required secrets to the conversion info
+// and, if enabled, checks the required secrets exist.
+func (p *templatePlugin) checkSecrets(ctx context.Context, req *core.ConvertArgs, info *ConvertInfo, names []string) error {
+	for _, name := range names {
+		info.addSecret(name)
+		if p.requiredSecrets == CheckOff || p.secretExists == nil {
+			continue
+		}
+		exists, err := p.secretExists(ctx, req.Repo, name)
+		if err != nil {
+			return &ServerError{
+				Err: fmt.Errorf("template converter: cannot find secret %q: %w", name, err),
+			}
+		}
+		if exists {
+			continue
+		}
+		err = fmt.Errorf("template converter: required secret %q does not exist", name)
+		if err := report(req, info, p.requiredSecrets, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkProduces returns an error if a rendered document does
+// not have the kind the template declares it produces.
+func checkProduces(data, name, kind string) error {
+	kinds, err := DocumentKinds(data)
+	if err != nil {
+		return err
 	}
+	for i, got := range kinds {
+		if got != kind {
+			return fmt.Errorf("template converter: template %q produces %s documents, but document %d has kind %q", name, kind, i+1, got)
+		}
+	}
+	return nil
 }
 
-type templatePlugin struct {
-	templateStore core.TemplateStore
-	stepLimit uint64
-	sizeLimit uint64
+// find returns the named template from the datastore. If the
+// name does not include a file extension, each extension in the
+// search order is appended to the name and the first template
+// found is returned.
+func (p *templatePlugin) find(ctx context.Context, name, namespace string) (*core.Template, error) {
+	if filepath.Ext(name) != "" {
+		template, err := p.templateStore.FindName(ctx, name, namespace)
+		if err == sql.ErrNoRows || (err == nil && template == nil) {
+			return nil, errTemplateNotFound
+		}
+		if err != nil {
+			return nil, &ServerError{Err: err}
+		}
+		return template, nil
+	}
+
+	order := p.searchOrder
+	if len(order) == 0 {
+		order = defaultSearchOrder
+	}
+	for _, ext := range order {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		template, err := p.templateStore.FindName(ctx, name+ext, namespace)
+		if err == sql.ErrNoRows || (err == nil && template == nil) {
+			continue
+		}
+		if err != nil {
+			return nil, &ServerError{Err: err}
+		}
+		return template, nil
+	}
+	return nil, errTemplateNotFound
 }
 
-func (p *templatePlugin) Convert(ctx context.Context, req *core.ConvertArgs) (*core.Config, error) {
-	// check type is yaml
-	configExt := filepath.Ext(req.Repo.Config)
+// checkParams validates the input parameters against the
+// schema declared in the template header, and the schema
+// returned by the resolver, if any.
+func (p *templatePlugin) checkParams(template *core.Template, params map[string]interface{}) error {
+	if template == nil {
+		return nil
+	}
+	if schema := templateSchema(template.Data); schema != nil {
+		if err := schema.Validate(params); err != nil {
+			return err
+		}
+	}
+	if p.schema == nil {
+		return nil
+	}
+	if schema := p.schema(template); schema != nil {
+		return schema.Validate(params)
+	}
+	return nil
+}
 
-	if configExt != ".yml" && configExt != ".yaml" {
-		return nil, nil
+// findRelative returns the named template from the datastore.
+// Folder-style names (e.g. team/base) are passed to the datastore
+// intact. If the template is rendered by a template in a folder,
+// names without a folder are first resolved relative to that
+// folder, falling back to the name as given.
+func (p *templatePlugin) findRelative(ctx context.Context, name, folder, namespace string) (*core.Template, error) {
+	if folder == "" || folder == "." || strings.Contains(name, "/") {
+		return p.find(ctx, name, namespace)
+	}
+	template, err := p.find(ctx, path.Join(folder, name), namespace)
+	if err == errTemplateNotFound {
+		return p.find(ctx, name, namespace)
 	}
+	return template, err
+}
 
-	// check kind is template
-	if templateFileRE.MatchString(req.Config.Data) == false {
-		return nil, nil
+// findFile returns the named file from the repository as
+// a template.
+func (p *templatePlugin) findFile(ctx context.Context, req *core.ConvertArgs, name string) (*core.Template, error) {
+	if p.fileService == nil || req.Build == nil {
+		return nil, errTemplateIncludeDisabled
+	}
+	// a missing file is a problem with the configuration
+	// file, while other errors are caused by the source
+	// control provider.
+	file, err := p.fileService.Find(ctx, req.User, req.Repo.Slug, req.Build.After, req.Build.Ref, name)
+	if errors.Is(err, scm.ErrNotFound) {
+		return nil, &UserError{
+			Err: fmt.Errorf("template converter: included file %q not found", name),
+		}
 	}
-	// map to templateArgs
-	var templateArgs core.TemplateArgs
-	err := yaml.Unmarshal([]byte(req.Config.Data), &templateArgs)
 	if err != nil {
-		return nil, errTemplateSyntaxErrors
+		return nil, &ServerError{Err: err}
+	}
+	return &core.Template{
+		Name:      name,
+		Namespace: req.Repo.Namespace,
+		Data:      string(file.Data),
+	}, nil
+}
+
+// includePath returns the path of the included file relative
+// to the root of the repository. The path is relative to the
+// directory of the configuration file, and must not resolve to
+// a path outside of the repository.
+func includePath(config, name string) (string, error) {
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("template converter: include path %q is outside the repository", name)
+	}
+	resolved := path.Join(path.Dir(config), name)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", fmt.Errorf("template converter: include path %q is outside the repository", name)
+	}
+	return resolved, nil
+}
+
+// include appends the name to the inclusion chain, returning
+// an error if the name is already included or the chain exceeds
+// the maximum inclusion depth.
+func (p *templatePlugin) include(chain []string, name string) ([]string, error) {
+	// copy the chain so that sibling inclusions do not
+	// share the same backing array.
+	next := make([]string, len(chain), len(chain)+1)
+	copy(next, chain)
+	next = append(next, name)
+
+	for _, included := range chain {
+		if included == name {
+			return nil, fmt.Errorf("template converter: inclusion cycle detected: %s", strings.Join(next, " -> "))
+		}
+	}
+
+	max := p.maxDepth
+	if max == 0 {
+		max = defaultMaxDepth
+	}
+	if len(next) > max {
+		return nil, fmt.Errorf("template converter: maximum inclusion depth of %d exceeded: %s", max, strings.Join(next, " -> "))
+	}
+	return next, nil
+}
+
+func (p *templatePlugin) parseTemplate(ctx context.Context, req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs, scope map[string]interface{}, info *ConvertInfo) (*core.Config, error) {
+	engine := p.engine(template, templateArgs.Load)
+	if engine == "" {
+		return nil, errTemplateExtensionInvalid
+	}
+
+	// the engine may be disabled server-wide.
+	if !p.engineEnabled(engine) {
+		return nil, fmt.Errorf("template converter: %s templates are disabled on this server", engine)
 	}
-	// get template from db
-	template, err := p.templateStore.FindName(ctx, templateArgs.Load, req.Repo.Namespace)
-	if err == sql.ErrNoRows {
-		return nil, errTemplateNotFound
+
+	// the repository may be restricted to yaml templates,
+	// in which case scripting engines are rejected.
+	if engine != engineYaml && p.yamlOnly != nil && p.yamlOnly(req.Repo) {
+		return nil, fmt.Errorf("template converter: %s templates are not permitted for repository %s, only yaml templates are allowed", engine, req.Repo.Slug)
+	}
+
+	// overly complex templates are rejected before
+	// they are executed.
+	if p.maxComplexity > 0 {
+		if err := checkComplexity(template, engine, p.maxComplexity); err != nil {
+			return nil, err
+		}
+	}
+
+	limits := p.limits(ctx, req)
+	var config *core.Config
+	var err error
+	switch engine {
+	case engineYaml:
+		var funcs templating.FuncMap
+		if funcs, err = p.repoFuncs(req.Repo); err != nil {
+			return nil, err
+		}
+		config, err = parseYaml(req, template, templateArgs, scope, funcs, p.inputAtTopLevel)
+	case engineStarlark:
+		// the output of print statements is captured as
+		// diagnostics, separate from the configuration.
+		print := func(msg string) {
+			info.Diagnostics = append(info.Diagnostics, template.Name+": "+msg)
+		}
+		// the execution trace is bounded, and entries
+		// after the limit are discarded.
+		var trace func(msg string)
+		if p.traceLimit > 0 {
+			trace = func(msg string) {
+				if len(info.Trace) < p.traceLimit {
+					info.Trace = append(info.Trace, template.Name+": "+msg)
+				}
+			}
+		}
+		config, err = parseStarlark(req, template, templateArgs, scope, limits.StepLimit, limits.SizeLimit, print, trace)
+	default:
+		config, err = parseJsonnet(req, template, templateArgs, scope)
+	}
+
+	// a template that fails to render using the syntax of
+	// another engine is likely named with the wrong file
+	// extension, which is reported in place of the error.
+	if err := checkEngineSyntax(template, engine, config, err); err != nil {
+		return nil, err
 	}
 	if err != nil {
 		return nil, err
 	}
 
-	switch filepath.Ext(templateArgs.Load) {
+	// the json output of scripting engines may be
+	// re-indented for readability.
+	if p.prettyPrint && engine != engineYaml {
+		config.Data = prettyPrint(config.Data)
+	}
+	return config, nil
+}
+
+// engineExtensions maps each engine to its preferred
+// file extension.
+var engineExtensions = map[string]string{
+	engineYaml:     ".yaml",
+	engineStarlark: ".star",
+	engineJsonnet:  ".jsonnet",
+}
+
+// starlarkSyntaxRE and jsonnetSyntaxRE match statements that
+// are distinctive of starlark and jsonnet templates.
+var (
+	starlarkSyntaxRE = regexp.MustCompile(`(?m)^[ \t]*(?:def[ \t]+[A-Za-z_][A-Za-z0-9_]*[ \t]*\(|load\([ \t]*["'])`)
+	jsonnetSyntaxRE  = regexp.MustCompile(`(?m)^[ \t]*local[ \t]+[A-Za-z_][A-Za-z0-9_]*[ \t]*[=(]`)
+)
+
+// engineSyntax returns the scripting engine whose syntax the
+// template data appears to use, or an empty string if the data
+// does not contain statements distinctive of an engine.
+func engineSyntax(data string) string {
+	switch {
+	case starlarkSyntaxRE.MatchString(data):
+		return engineStarlark
+	case jsonnetSyntaxRE.MatchString(data):
+		return engineJsonnet
+	default:
+		return ""
+	}
+}
+
+// checkEngineSyntax returns an error suggesting the intended
+// engine if the template failed to render and appears to use
+// the syntax of another engine. The output of yaml templates is
+// decoded, since yaml templates are not parsed when rendered.
+func checkEngineSyntax(template *core.Template, engine string, config *core.Config, err error) error {
+	syntax := engineSyntax(template.Data)
+	if syntax == "" || syntax == engine {
+		return nil
+	}
+	if err == nil && engine == engineYaml {
+		_, err = decodeDocuments(config.Data)
+	}
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("template converter: template %q is rendered as %s, but appears to contain %s syntax; use the %s file extension: %w",
+		template.Name, engine, syntax, engineExtensions[syntax], err)
+}
+
+// dedupDocuments removes documents that are byte-identical to a
+// previous document in the configuration file, and returns the
+// number of documents removed. The configuration file is returned
+// unchanged if there are no duplicate documents.
+func dedupDocuments(data string) (string, int) {
+	docs := splitDocuments(data)
+	seen := map[string]struct{}{}
+	var kept []string
+	for _, doc := range docs {
+		if _, ok := seen[doc]; ok {
+			continue
+		}
+		seen[doc] = struct{}{}
+		kept = append(kept, doc)
+	}
+	removed := len(docs) - len(kept)
+	if removed == 0 {
+		return data, 0
+	}
+	var buf strings.Builder
+	for _, doc := range kept {
+		buf.WriteString("---\n")
+		buf.WriteString(doc)
+		if !strings.HasSuffix(doc, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String(), removed
+}
+
+// provenanceKind is the kind of the provenance document.
+const provenanceKind = "provenance"
+
+// appendProvenance appends a comment to the configuration file
+// listing the templates used to render the configuration file.
+// Each line of the comment is a line of a yaml document, which
+// auditors can decode once the comment prefix is removed. The
+// record is not a document of its own, since every document is
+// parsed and scheduled as a pipeline stage.
+func appendProvenance(data string, info *ConvertInfo) string {
+	type provenanceTemplate struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace,omitempty"`
+		Version   int64  `yaml:"version,omitempty"`
+		Checksum  string `yaml:"checksum"`
+		Engine    string `yaml:"engine"`
+		Rendered  string `yaml:"rendered"`
+	}
+	doc := struct {
+		Kind      string               `yaml:"kind"`
+		Templates []provenanceTemplate `yaml:"templates"`
+	}{Kind: provenanceKind}
+	for _, usage := range info.Templates {
+		doc.Templates = append(doc.Templates, provenanceTemplate{
+			Name:      usage.Name,
+			Namespace: usage.Namespace,
+			Version:   usage.Version,
+			Checksum:  usage.Checksum,
+			Engine:    usage.Engine,
+			Rendered:  usage.Rendered.UTC().Format(time.RFC3339),
+		})
+	}
+	out, _ := yaml.Marshal(doc)
+	var buf strings.Builder
+	buf.WriteString(data)
+	if data != "" && !strings.HasSuffix(data, "\n") {
+		buf.WriteString("\n")
+	}
+	for _, line := range splitLines(string(out)) {
+		buf.WriteString("# ")
+		buf.WriteString(line)
+	}
+	return buf.String()
+}
+
+// prettyPrint re-indents each json document in the configuration
+// file using two spaces, and separates documents using a document
+// separator. Documents that are not valid json are not changed.
+func prettyPrint(data string) string {
+	var buf strings.Builder
+	for _, doc := range splitDocuments(data) {
+		doc = strings.TrimSpace(doc)
+		var out bytes.Buffer
+		if err := json.Indent(&out, []byte(doc), "", "  "); err == nil {
+			doc = out.String()
+		}
+		buf.WriteString("---\n")
+		buf.WriteString(doc)
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// limits returns the limits applied when rendering templates.
+// The limits configured for the plugin may be overridden using
+// the context, except for builds triggered by a webhook.
+func (p *templatePlugin) limits(ctx context.Context, req *core.ConvertArgs) Limits {
+	limits := Limits{
+		StepLimit: p.stepLimit,
+		SizeLimit: p.sizeLimit,
+	}
+	overrides, ok := limitsFrom(ctx)
+	if !ok || (req.Build != nil && req.Build.Trigger == core.TriggerHook) {
+		return limits
+	}
+	if overrides.StepLimit != 0 {
+		limits.StepLimit = overrides.StepLimit
+	}
+	if overrides.SizeLimit != 0 {
+		limits.SizeLimit = overrides.SizeLimit
+	}
+	return limits
+}
+
+// engineEnabled returns true if the engine is enabled. All
+// engines are enabled if no engines are configured.
+func (p *templatePlugin) engineEnabled(engine string) bool {
+	if len(p.engines) == 0 {
+		return true
+	}
+	for _, enabled := range p.engines {
+		if enabled == engine {
+			return true
+		}
+	}
+	return false
+}
+
+// engine returns the engine used to render the template.
+// If engine hints are enabled, the engine may be declared on the
+// first line of the template, overriding the file extension.
+func (p *templatePlugin) engine(template *core.Template, name string) string {
+	if p.engineHints && template != nil {
+		if engine := engineHint(template.Data); engine != "" {
+			return engine
+		}
+	}
+	return templateEngine(name)
+}
+
+// engineHint returns the engine declared in a comment on the
+// first line of the template (e.g. // jsonnet or # starlark),
+// or an empty string if the template does not declare an engine.
+func engineHint(data string) string {
+	line := data
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "//"):
+		line = line[2:]
+	case strings.HasPrefix(line, "#"):
+		line = line[1:]
+	default:
+		return ""
+	}
+	switch engine := strings.TrimSpace(line); engine {
+	case engineYaml, engineStarlark, engineJsonnet:
+		return engine
+	default:
+		return ""
+	}
+}
+
+// templateEngine returns the engine used to render the named
+// template, based on the file extension. An empty string is
+// returned if the file extension is not supported.
+func templateEngine(name string) string {
+	switch filepath.Ext(name) {
 	case ".yml", ".yaml":
-		return parseYaml(req, template, templateArgs)
+		return engineYaml
 	case ".star", ".starlark", ".script":
-		return parseStarlark(req, template, templateArgs, p.stepLimit, p.sizeLimit)
+		return engineStarlark
 	case ".jsonnet":
-		return parseJsonnet(req, template, templateArgs)
+		return engineJsonnet
 	default:
-		return nil, errTemplateExtensionInvalid
+		return ""
+	}
+}
+
+// validate checks the rendered configuration against the
+// restrictions configured for the plugin. Checks that fail in
+// warning mode are added to the conversion info.
+func (p *templatePlugin) validate(req *core.ConvertArgs, config *core.Config, info *ConvertInfo) error {
+	// tabs are detected before the documents are decoded,
+	// which fails with a generic syntax error.
+	if p.tabs != CheckOff {
+		if err := report(req, info, p.tabs, checkTabs(config.Data)); err != nil {
+			return err
+		}
+	}
+
+	// duplicate keys are discarded when documents are
+	// decoded, and are detected using the raw documents.
+	if p.duplicateKeys != CheckOff {
+		if err := report(req, info, p.duplicateKeys, checkDuplicateKeys(config.Data)); err != nil {
+			return err
+		}
+	}
+
+	var checks []func(docs []map[string]interface{}) error
+	if p.allowRunners != nil {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return checkRunners(docs, p.allowRunners(req.Repo))
+		})
+	}
+	if len(p.reservedKeys) != 0 {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return checkReservedKeys(docs, p.reservedKeys)
+		})
+	}
+	if p.maxTotalSteps > 0 {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return checkTotalSteps(docs, p.maxTotalSteps)
+		})
+	}
+	if p.maxNesting > 0 {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return checkNesting(docs, p.maxNesting)
+		})
+	}
+	if p.pinnedImages != CheckOff {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return report(req, info, p.pinnedImages, checkPinnedImages(docs))
+		})
+	}
+	if p.forbiddenCommands != CheckOff && len(p.commandPatterns) != 0 {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return report(req, info, p.forbiddenCommands, checkCommands(docs, p.commandPatterns))
+		})
+	}
+	if p.unknownFields != CheckOff {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return report(req, info, p.unknownFields, checkPipelineFields(docs))
+		})
+	}
+	if p.knownKinds != CheckOff {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return report(req, info, p.knownKinds, checkKinds(docs))
+		})
+	}
+	if p.requireName != CheckOff {
+		checks = append(checks, func(docs []map[string]interface{}) error {
+			return report(req, info, p.requireName, checkPipelineNames(docs))
+		})
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+
+	docs, err := parseDocuments(config.Data)
+	if err != nil {
+		return err
+	}
+	for _, check := range checks {
+		if err := check(docs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transform applies the transformations configured for the
+// plugin to the rendered configuration. The configuration is
+// only re-encoded if a document is changed.
+func (p *templatePlugin) transform(config *core.Config) error {
+	var transforms []func(doc yaml.MapSlice) bool
+	if p.mirror != "" || len(p.mirrorTable) != 0 {
+		transforms = append(transforms, func(doc yaml.MapSlice) bool {
+			return mirrorImages(doc, p.mirror, p.mirrorTable)
+		})
+	}
+	switch p.keyOrder {
+	case KeyOrderSorted:
+		transforms = append(transforms, sortKeys)
+	case KeyOrderSource:
+		// the documents are re-encoded in canonical form,
+		// preserving the order of keys.
+		transforms = append(transforms, func(yaml.MapSlice) bool {
+			return true
+		})
+	}
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	docs, err := decodeDocuments(config.Data)
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for _, doc := range docs {
+		for _, transform := range transforms {
+			if transform(doc) {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := encodeDocuments(docs)
+	if err != nil {
+		return err
+	}
+	config.Data = data
+	return nil
+}
+
+// mirrorImages rewrites the step and service images of the
+// pipeline document to use the registry mirror. Images found in
+// the lookup table are replaced with the mapped image, and all
+// other images are prefixed with the mirror address. Images that
+// already use the mirror are not changed.
+func mirrorImages(doc yaml.MapSlice, mirror string, table map[string]string) bool {
+	if kind, _ := lookup(doc, "kind").(string); kind != "pipeline" {
+		return false
+	}
+	var changed bool
+	for _, section := range []string{"steps", "services"} {
+		items, _ := lookup(doc, section).([]interface{})
+		for _, item := range items {
+			container, ok := item.(yaml.MapSlice)
+			if !ok {
+				continue
+			}
+			for i := range container {
+				if container[i].Key != "image" {
+					continue
+				}
+				image, ok := container[i].Value.(string)
+				if !ok {
+					continue
+				}
+				if mirrored := mirrorImage(image, mirror, table); mirrored != image {
+					container[i].Value = mirrored
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// mirrorImage returns the image rewritten to use the registry
+// mirror.
+func mirrorImage(image, mirror string, table map[string]string) string {
+	if mapped, ok := table[image]; ok {
+		return mapped
+	}
+	if mirror == "" || strings.HasPrefix(image, mirror+"/") {
+		return image
+	}
+	return mirror + "/" + image
+}
+
+// parseDocuments decodes each document in the rendered
+// configuration. Empty documents are skipped.
+func parseDocuments(data string) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	dec := yaml.NewDecoder(strings.NewReader(data))
+	for {
+		var doc map[string]interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// truncateSteps truncates the steps of each pipeline in the
+// rendered configuration to the first n steps. Truncated
+// pipelines are marked with a comment.
+func truncateSteps(data string, n int) (string, error) {
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, doc := range docs {
+		buf.WriteString("---\n")
+		for i, item := range doc {
+			if item.Key != "steps" {
+				continue
+			}
+			steps, ok := item.Value.([]interface{})
+			if !ok || len(steps) <= n {
+				continue
+			}
+			fmt.Fprintf(&buf, "# preview truncated: showing %d of %d steps\n", n, len(steps))
+			doc[i].Value = steps[:n]
+		}
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(out)
+	}
+	return buf.String(), nil
+}
+
+// skipDocument returns true if the rendered configuration
+// includes a skip document.
+func skipDocument(data string) bool {
+	docs, err := parseDocuments(data)
+	if err != nil {
+		return false
+	}
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind == core.ConfigKindSkip {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTabs returns an error if the leading whitespace of a line
+// of the yaml document includes a tab, which yaml does not allow,
+// since the yaml decoder otherwise reports a confusing syntax
+// error.
+func checkTabs(data string) error {
+	for i, line := range strings.Split(data, "\n") {
+		text := strings.TrimLeft(line, " \t")
+		if text != "" && strings.Contains(line[:len(line)-len(text)], "\t") {
+			return fmt.Errorf("template converter: tabs are not allowed for indentation at line %d", i+1)
+		}
+	}
+	return nil
+}
+
+// checkRunners returns an error if a pipeline document
+// targets a runner that is not included in the allow list.
+func checkRunners(docs []map[string]interface{}, allow []string) error {
+	if len(allow) == 0 {
+		return nil
+	}
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		runner := pipelineRunner(doc)
+		if !allowedRunner(runner, allow) {
+			name, _ := doc["name"].(string)
+			return fmt.Errorf("template converter: pipeline %q targets runner %q which is not allowed", name, strings.Join(runner, "/"))
+		}
+	}
+	return nil
+}
+
+// report reports the result of a check according to the check
+// mode. Warnings are logged and added to the conversion info, and
+// errors are returned.
+func report(req *core.ConvertArgs, info *ConvertInfo, mode CheckMode, err error) error {
+	if err == nil {
+		return nil
+	}
+	if mode == CheckError {
+		return err
+	}
+	logrus.WithError(err).
+		WithField("repo", req.Repo.Slug).
+		Warnln("template converter: check failed")
+	info.Warnings = append(info.Warnings, err.Error())
+	return nil
+}
+
+// checkTotalSteps returns an error if the number of steps across
+// all pipeline documents exceeds the maximum.
+func checkTotalSteps(docs []map[string]interface{}, max int) error {
+	var total int
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		steps, _ := doc["steps"].([]interface{})
+		total += len(steps)
+	}
+	if total > max {
+		return fmt.Errorf("template converter: configuration defines %d steps, exceeding the maximum of %d steps", total, max)
+	}
+	return nil
+}
+
+// checkNesting returns an error if a document exceeds the
+// maximum nesting depth.
+func checkNesting(docs []map[string]interface{}, max int) error {
+	for i, doc := range docs {
+		if depth := nestingDepth(doc); depth > max {
+			return fmt.Errorf("template converter: document %d has a nesting depth of %d, exceeding the maximum of %d", i+1, depth, max)
+		}
+	}
+	return nil
+}
+
+// nestingDepth returns the nesting depth of the value, where
+// each map or list adds a level.
+func nestingDepth(value interface{}) int {
+	var depth int
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			if d := nestingDepth(item); d > depth {
+				depth = d
+			}
+		}
+	case map[interface{}]interface{}:
+		for _, item := range v {
+			if d := nestingDepth(item); d > depth {
+				depth = d
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if d := nestingDepth(item); d > depth {
+				depth = d
+			}
+		}
+	default:
+		return 0
+	}
+	return depth + 1
+}
+
+// mergeKeyRE matches a yaml merge key (<<) in block style.
+var mergeKeyRE = regexp.MustCompile(`(?m)^([ \t]*(?:-[ \t]+)?)<<[ \t]*:`)
+
+// checkDuplicateKeys returns an error if a document sets the same
+// key more than once in a map. Merge keys are renamed before the
+// documents are decoded, since merged keys may be overridden.
+func checkDuplicateKeys(data string) error {
+	n := 0
+	data = mergeKeyRE.ReplaceAllStringFunc(data, func(s string) string {
+		n++
+		prefix := mergeKeyRE.FindStringSubmatch(s)[1]
+		return fmt.Sprintf("%s\"<<%d\":", prefix, n)
+	})
+	docs, err := decodeDocuments(data)
+	if err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		if key, ok := duplicateKey(doc, ""); ok {
+			return fmt.Errorf("template converter: document %d has duplicate key %q", i+1, key)
+		}
+	}
+	return nil
+}
+
+// duplicateKey returns the path of the first key set more than
+// once in the value or a nested value (e.g. steps[0].image).
+func duplicateKey(v interface{}, path string) (string, bool) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		seen := map[string]bool{}
+		for _, item := range v {
+			key := fmt.Sprint(item.Key)
+			if strings.HasPrefix(key, "<<") {
+				continue
+			}
+			if path != "" {
+				key = path + "." + key
+			}
+			if seen[key] {
+				return key, true
+			}
+			seen[key] = true
+			if dup, ok := duplicateKey(item.Value, key); ok {
+				return dup, true
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if dup, ok := duplicateKey(item, fmt.Sprintf("%s[%d]", path, i)); ok {
+				return dup, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkCommands returns an error if a pipeline step or service
+// command matches a forbidden pattern.
+func checkCommands(docs []map[string]interface{}, patterns []*regexp.Regexp) error {
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		for _, section := range []string{"steps", "services"} {
+			items, _ := doc[section].([]interface{})
+			for _, item := range items {
+				container, _ := item.(map[interface{}]interface{})
+				commands, _ := container["commands"].([]interface{})
+				for _, v := range commands {
+					command, ok := v.(string)
+					if !ok {
+						continue
+					}
+					for _, pattern := range patterns {
+						if !pattern.MatchString(command) {
+							continue
+						}
+						pipeline, _ := doc["name"].(string)
+						name, _ := container["name"].(string)
+						return fmt.Errorf("template converter: pipeline %q step %q command %q matches forbidden pattern %q", pipeline, name, command, pattern.String())
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkPinnedImages returns an error if a pipeline step or
+// service image is not pinned to a digest.
+func checkPinnedImages(docs []map[string]interface{}) error {
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		for _, section := range []string{"steps", "services"} {
+			items, _ := doc[section].([]interface{})
+			for _, item := range items {
+				container, _ := item.(map[interface{}]interface{})
+				image, _ := container["image"].(string)
+				if image == "" || strings.Contains(image, "@sha256:") {
+					continue
+				}
+				pipeline, _ := doc["name"].(string)
+				name, _ := container["name"].(string)
+				return fmt.Errorf("template converter: pipeline %q step %q image %q is not pinned to a digest", pipeline, name, image)
+			}
+		}
 	}
+	return nil
 }
 
-func parseYaml(req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs) (*core.Config, error) {
+// checkPipelineFields returns an error if a pipeline document
+// sets a top-level field that is not a known pipeline field.
+func checkPipelineFields(docs []map[string]interface{}) error {
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		var unknown []string
+		for key := range doc {
+			if !knownField(key) {
+				unknown = append(unknown, key)
+			}
+		}
+		if len(unknown) != 0 {
+			sort.Strings(unknown)
+			name, _ := doc["name"].(string)
+			return fmt.Errorf("template converter: pipeline %q has unknown fields: %s", name, strings.Join(unknown, ", "))
+		}
+	}
+	return nil
+}
+
+// knownField returns true if the field is a known top-level
+// pipeline field.
+func knownField(field string) bool {
+	for _, known := range pipelineFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKinds returns an error if a document does not have a
+// known kind.
+func checkKinds(docs []map[string]interface{}) error {
+	for i, doc := range docs {
+		kind, _ := doc["kind"].(string)
+		if !knownKind(kind) {
+			return fmt.Errorf("template converter: document %d has unknown kind %q", i+1, kind)
+		}
+	}
+	return nil
+}
+
+// knownKind returns true if the document kind is known.
+func knownKind(kind string) bool {
+	for _, known := range knownKinds {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPipelineNames returns an error if a pipeline document
+// does not set an explicit name.
+func checkPipelineNames(docs []map[string]interface{}) error {
+	for i, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		if name, _ := doc["name"].(string); name == "" {
+			return fmt.Errorf("template converter: pipeline in document %d does not set a name", i+1)
+		}
+	}
+	return nil
+}
+
+// checkReservedKeys returns an error if a pipeline document
+// sets a reserved key. Nested keys are separated by a period
+// (e.g. clone.disable).
+func checkReservedKeys(docs []map[string]interface{}, keys []string) error {
+	for _, doc := range docs {
+		if kind, _ := doc["kind"].(string); kind != "pipeline" {
+			continue
+		}
+		for _, key := range keys {
+			if hasKey(doc, strings.Split(key, ".")) {
+				name, _ := doc["name"].(string)
+				return fmt.Errorf("template converter: pipeline %q sets reserved key %q", name, key)
+			}
+		}
+	}
+	return nil
+}
+
+// hasKey returns true if the document contains the key path.
+func hasKey(doc map[string]interface{}, path []string) bool {
+	var value interface{} = doc
+	for _, key := range path {
+		switch m := value.(type) {
+		case map[string]interface{}:
+			v, ok := m[key]
+			if !ok {
+				return false
+			}
+			value = v
+		case map[interface{}]interface{}:
+			v, ok := m[key]
+			if !ok {
+				return false
+			}
+			value = v
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// pipelineRunner returns the pipeline type, platform os and
+// platform architecture of the pipeline document. The pipeline
+// type defaults to docker when unset.
+func pipelineRunner(doc map[string]interface{}) []string {
+	kind, _ := doc["type"].(string)
+	if kind == "" {
+		kind = "docker"
+	}
+	runner := []string{kind}
+	platform, _ := doc["platform"].(map[interface{}]interface{})
+	if os, _ := platform["os"].(string); os != "" {
+		runner = append(runner, os)
+		if arch, _ := platform["arch"].(string); arch != "" {
+			runner = append(runner, arch)
+		}
+	}
+	return runner
+}
+
+// allowedRunner returns true if the runner matches an entry in
+// the allow list. An entry matches when it is equal to a prefix
+// of the runner type, os and architecture.
+func allowedRunner(runner []string, allow []string) bool {
+	for _, entry := range allow {
+		for i := range runner {
+			if entry == strings.Join(runner[:i+1], "/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// repoFuncs returns the functions available to yaml templates
+// in the repository. The helpers are added to the default
+// functions, while the functions resolved for the namespace only
+// include the helpers they name, so that namespaces restricted to
+// fewer functions are not given the helpers.
+func (p *templatePlugin) repoFuncs(repo *core.Repository) (templating.FuncMap, error) {
+	helpers, err := p.helperFuncs(repo)
+	if err != nil {
+		return nil, err
+	}
+	funcs := templating.FuncMap{}
+	if p.funcs != nil {
+		if resolved := p.funcs(repo.Namespace); resolved != nil {
+			for name, fn := range resolved {
+				if helper, ok := helpers[name]; ok {
+					fn = helper
+				}
+				funcs[name] = fn
+			}
+			return funcs, nil
+		}
+	}
+	for name, fn := range defaultFuncs {
+		funcs[name] = fn
+	}
+	for name, fn := range helpers {
+		funcs[name] = fn
+	}
+	return funcs, nil
+}
+
+// helperFuncs returns the helper functions bound to the
+// repository, including the flag function which reports whether
+// a feature flag is enabled for the repository, the locale-aware
+// formatting functions, and the time and random functions.
+// Unknown flags are disabled, and an error is returned if the
+// locale is not supported.
+func (p *templatePlugin) helperFuncs(repo *core.Repository) (templating.FuncMap, error) {
+	var enabled func(flag string) bool
+	if p.flags != nil {
+		enabled = p.flags(repo)
+	}
+	funcs := templating.FuncMap{
+		"flag": func(flag string) bool {
+			return enabled != nil && enabled(flag)
+		},
+	}
+	locale, err := localeFuncs(p.locale)
+	if err != nil {
+		return nil, err
+	}
+	for name, fn := range locale {
+		funcs[name] = fn
+	}
+	seed := time.Now().UnixNano()
+	if p.seed != nil {
+		seed = p.seed()
+	}
+	for name, fn := range clockFuncs(p.now, seed) {
+		funcs[name] = fn
+	}
+	return funcs, nil
+}
+
+func parseYaml(req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs, scope map[string]interface{}, funcs templating.FuncMap, inputAtTopLevel bool) (*core.Config, error) {
 	data := map[string]interface{}{
-		"build": toBuild(req.Build),
-		"repo":  toRepo(req.Repo),
+		"build": templateBuild(req.Build, req.History),
+		"repo":  templateRepo(req.Repo),
 		"input": templateArgs.Data,
 	}
-	tmpl, err := templating.New(template.Name).Funcs(funcmap.SafeFuncs).Parse(template.Data)
+	for key, value := range scope {
+		if _, ok := data[key]; !ok {
+			data[key] = value
+		}
+	}
+
+	// the input may be merged into the top-level scope. the
+	// build, repo and input values take precedence, and remain
+	// accessible using the input, while input that collides with
+	// any other value is ambiguous.
+	if inputAtTopLevel {
+		for key, value := range templateArgs.Data {
+			switch key {
+			case "build", "repo", "input":
+				continue
+			}
+			if _, ok := data[key]; ok {
+				return nil, fmt.Errorf("template converter: input %q is ambiguous at the top level, use .input.%s", key, key)
+			}
+			data[key] = value
+		}
+	}
+	tmpl, err := templating.New(template.Name).Funcs(funcs).Parse(template.Data)
 	if err != nil {
 		return nil, err
 	}
@@ -114,8 +2767,158 @@ func parseYaml(req *core.ConvertArgs, template *core.Template, templateArgs core
 	}, nil
 }
 
-func parseJsonnet(req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs) (*core.Config, error) {
-	file, err := jsonnet.Parse(req, nil, 0, template, templateArgs.Data)
+// templateBuild returns the build parameters exposed to yaml
+// templates. The keys match the build parameters exposed to
+// starlark and jsonnet templates. The field names of the build
+// are retained for compatibility (e.g. .build.Event).
+func templateBuild(v *core.Build, history []*core.Build) map[string]interface{} {
+	if v == nil {
+		v = new(core.Build)
+	}
+	labels := v.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	build := toBuild(v)
+	return map[string]interface{}{
+		"event":         v.Event,
+		"action":        v.Action,
+		"cron":          v.Cron,
+		"trigger":       v.Trigger,
+		"environment":   v.Deploy,
+		"link":          v.Link,
+		"branch":        v.Target,
+		"source":        v.Source,
+		"before":        v.Before,
+		"after":         v.After,
+		"target":        v.Target,
+		"ref":           v.Ref,
+		"commit":        v.After,
+		"title":         v.Title,
+		"message":       v.Message,
+		"source_repo":   v.Fork,
+		"author":        v.AuthorName,
+		"author_login":  v.Author,
+		"author_name":   v.AuthorName,
+		"author_email":  v.AuthorEmail,
+		"author_avatar": v.AuthorAvatar,
+		"sender":        v.Sender,
+		"debug":         v.Debug,
+		"params":        v.Params,
+		"labels":        labels,
+		"history":       templateHistory(history),
+
+		"ID":           build.ID,
+		"RepoID":       build.RepoID,
+		"Trigger":      build.Trigger,
+		"Number":       build.Number,
+		"Parent":       build.Parent,
+		"Status":       build.Status,
+		"Error":        build.Error,
+		"Event":        build.Event,
+		"Action":       build.Action,
+		"Link":         build.Link,
+		"Timestamp":    build.Timestamp,
+		"Title":        build.Title,
+		"Message":      build.Message,
+		"Before":       build.Before,
+		"After":        build.After,
+		"Ref":          build.Ref,
+		"Fork":         build.Fork,
+		"Source":       build.Source,
+		"Target":       build.Target,
+		"Author":       build.Author,
+		"AuthorName":   build.AuthorName,
+		"AuthorEmail":  build.AuthorEmail,
+		"AuthorAvatar": build.AuthorAvatar,
+		"Sender":       build.Sender,
+		"Params":       build.Params,
+		"Deploy":       build.Deploy,
+		"Started":      build.Started,
+		"Finished":     build.Finished,
+		"Created":      build.Created,
+		"Updated":      build.Updated,
+		"Version":      build.Version,
+	}
+}
+
+// templateRepo returns the repository parameters exposed to
+// yaml templates. The keys match the repository parameters
+// exposed to starlark and jsonnet templates, and the branch and
+// default branch are always exposed, empty if unknown. The field
+// names of the repository are retained for compatibility (e.g.
+// .repo.Slug).
+func templateRepo(v *core.Repository) map[string]interface{} {
+	if v == nil {
+		v = new(core.Repository)
+	}
+	repo := toRepo(v)
+	return map[string]interface{}{
+		"uid":                  v.UID,
+		"name":                 v.Name,
+		"namespace":            v.Namespace,
+		"slug":                 v.Slug,
+		"git_http_url":         v.HTTPURL,
+		"git_ssh_url":          v.SSHURL,
+		"link":                 v.Link,
+		"branch":               v.Branch,
+		"default_branch":       v.Branch,
+		"config":               v.Config,
+		"private":              v.Private,
+		"visibility":           v.Visibility,
+		"active":               v.Active,
+		"trusted":              v.Trusted,
+		"protected":            v.Protected,
+		"ignore_forks":         v.IgnoreForks,
+		"ignore_pull_requests": v.IgnorePulls,
+
+		"ID":         repo.ID,
+		"UID":        repo.UID,
+		"UserID":     repo.UserID,
+		"Namespace":  repo.Namespace,
+		"Name":       repo.Name,
+		"Slug":       repo.Slug,
+		"SCM":        repo.SCM,
+		"HTTPURL":    repo.HTTPURL,
+		"SSHURL":     repo.SSHURL,
+		"Link":       repo.Link,
+		"Branch":     repo.Branch,
+		"Private":    repo.Private,
+		"Visibility": repo.Visibility,
+		"Active":     repo.Active,
+		"Config":     repo.Config,
+		"Trusted":    repo.Trusted,
+		"Protected":  repo.Protected,
+		"Timeout":    repo.Timeout,
+	}
+}
+
+// templateHistory returns the prior builds exposed to yaml
+// templates, limited to the most recent builds.
+func templateHistory(history []*core.Build) []map[string]interface{} {
+	if len(history) > maxHistory {
+		history = history[:maxHistory]
+	}
+	out := []map[string]interface{}{}
+	for _, v := range history {
+		if v == nil {
+			continue
+		}
+		out = append(out, map[string]interface{}{
+			"number":   v.Number,
+			"status":   v.Status,
+			"event":    v.Event,
+			"ref":      v.Ref,
+			"commit":   v.After,
+			"created":  v.Created,
+			"finished": v.Finished,
+		})
+	}
+	return out
+}
+
+func parseJsonnet(req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs, scope map[string]interface{}) (*core.Config, error) {
+	file, err := jsonnet.Parse(req, nil, 0, template, templateArgs.Data, scope)
 	if err != nil {
 		return nil, err
 	}
@@ -124,8 +2927,8 @@ func parseJsonnet(req *core.ConvertArgs, template *core.Template, templateArgs c
 	}, nil
 }
 
-func parseStarlark(req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs, stepLimit uint64, sizeLimit uint64) (*core.Config, error) {
-	file, err := starlark.Parse(req, template, templateArgs.Data, stepLimit, sizeLimit)
+func parseStarlark(req *core.ConvertArgs, template *core.Template, templateArgs core.TemplateArgs, scope map[string]interface{}, stepLimit uint64, sizeLimit uint64, print, trace func(msg string)) (*core.Config, error) {
+	file, err := starlark.Parse(req, template, templateArgs.Data, scope, stepLimit, sizeLimit, print, trace)
 	if err != nil {
 		return nil, err
 	}
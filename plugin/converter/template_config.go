@@ -0,0 +1,233 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package converter
+
+import (
+	"context"
+	"regexp"
+	templating "text/template"
+	"time"
+
+	"github.com/drone/drone/core"
+)
+
+// TemplateConfig configures the template conversion plugin.
+// Each setting corresponds to a TemplateOption, and zero values
+// disable the setting or use the default.
+type TemplateConfig struct {
+	// StepLimit is the maximum number of execution steps of
+	// a starlark template.
+	StepLimit uint64
+
+	// SizeLimit is the maximum size, in bytes, of the
+	// configuration generated by a starlark template.
+	SizeLimit uint64
+
+	// MaxInclusionDepth limits the number of nested template
+	// inclusions. See TemplateMaxInclusionDepth.
+	MaxInclusionDepth int
+
+	// MaxTotalSteps limits the number of steps across all
+	// pipelines. See TemplateMaxTotalSteps.
+	MaxTotalSteps int
+
+	// MaxNestingDepth limits the nesting depth of rendered
+	// documents. See TemplateMaxNestingDepth.
+	MaxNestingDepth int
+
+	// MaxComplexity limits the static complexity of starlark
+	// and jsonnet templates. See TemplateMaxComplexity.
+	MaxComplexity int
+
+	// MaxDataSize limits the size of the data block of
+	// template documents. See TemplateMaxDataSize.
+	MaxDataSize int
+
+	// ExtensionSearchOrder sets the order in which file
+	// extensions are searched. See TemplateExtensionSearchOrder.
+	ExtensionSearchOrder []string
+
+	// EnabledEngines sets the engines enabled server-wide.
+	// See TemplateEnabledEngines.
+	EnabledEngines []string
+
+	// EngineHints selects the engine declared on the first
+	// line of a template. See TemplateEngineHints.
+	EngineHints bool
+
+	// YamlOnly restricts repositories to yaml templates.
+	// See TemplateYamlOnly.
+	YamlOnly func(repo *core.Repository) bool
+
+	// AllowRunners restricts the runners a pipeline may
+	// target. See TemplateAllowRunners.
+	AllowRunners func(repo *core.Repository) []string
+
+	// ReservedKeys lists the keys a pipeline may not set.
+	// See TemplateReservedKeys.
+	ReservedKeys []string
+
+	// Funcs resolves the functions available to yaml
+	// templates. See TemplateFuncs.
+	Funcs func(namespace string) templating.FuncMap
+
+	// FeatureFlags resolves the feature flags exposed to yaml
+	// templates. See TemplateFeatureFlags.
+	FeatureFlags func(repo *core.Repository) func(flag string) bool
+
+	// ValuesTemplate is the name of the template providing
+	// the shared values of each namespace. See TemplateValues.
+	ValuesTemplate string
+
+	// ServerURL and ServerVersion are exposed to templates
+	// under the server scope. See TemplateServer.
+	ServerURL     string
+	ServerVersion string
+
+	// Locale sets the locale of the formatting functions.
+	// See TemplateLocale.
+	Locale string
+
+	// Clock and RandSeed set the sources of the time and
+	// random functions. See TemplateClock and TemplateRandSeed.
+	Clock    func() time.Time
+	RandSeed func() int64
+
+	// InputAtTopLevel merges the input into the top-level
+	// scope of yaml templates. See TemplateInputAtTopLevel.
+	InputAtTopLevel bool
+
+	// OrgResolver resolves organization metadata. See
+	// TemplateOrgResolver.
+	OrgResolver func(namespace string) (map[string]interface{}, error)
+
+	// FileService enables including repository files. See
+	// TemplateFileService.
+	FileService core.FileService
+
+	// NamePattern is the pattern template names must match.
+	// See TemplateNamePattern.
+	NamePattern *regexp.Regexp
+
+	// NameNormalizer normalizes template names. See
+	// TemplateNameNormalizer.
+	NameNormalizer func(name string) string
+
+	// LoadRewriter rewrites the names of loaded templates.
+	// See TemplateLoadRewriter.
+	LoadRewriter func(ctx context.Context, repo *core.Repository, load string) (string, error)
+
+	// SecretExists looks up the secrets templates require.
+	// See TemplateRequiredSecrets.
+	SecretExists func(ctx context.Context, repo *core.Repository, name string) (bool, error)
+
+	// ParamsSchema resolves the input parameters schema of a
+	// template. See TemplateParamsSchema.
+	ParamsSchema func(template *core.Template) *ParamsSchema
+
+	// DocumentTransform transforms each document of the
+	// configuration file. See TemplateDocumentTransform.
+	DocumentTransform func(ctx context.Context, repo *core.Repository, doc string, rendered bool) (string, error)
+
+	// Linter lints the rendered configuration file. See
+	// TemplateLinter.
+	Linter LintFunc
+
+	// RegistryMirror and RegistryMirrorTable rewrite images
+	// to a registry mirror. See TemplateRegistryMirror.
+	RegistryMirror      string
+	RegistryMirrorTable map[string]string
+
+	// CacheSize and CacheTTL enable caching of converted
+	// configuration files. See TemplateCache.
+	CacheSize int
+	CacheTTL  time.Duration
+
+	// StoreRetryAttempts and StoreRetryBackoff retry finding
+	// templates when the datastore times out. See
+	// TemplateWithStoreRetry.
+	StoreRetryAttempts int
+	StoreRetryBackoff  time.Duration
+
+	// NegativeCacheSize and NegativeCacheTTL enable caching
+	// of templates not found. See TemplateNegativeCache.
+	NegativeCacheSize int
+	NegativeCacheTTL  time.Duration
+
+	// Cache is the cache backend used in place of the
+	// in-memory caches. See TemplateCacheBackend.
+	Cache Cache
+
+	// Canonicalize re-encodes the rendered configuration.
+	// See TemplateCanonicalize.
+	Canonicalize KeyOrder
+
+	// SeparatorStyle sets the placement of document
+	// separators. See TemplateSeparatorStyle.
+	SeparatorStyle SeparatorStyle
+
+	// PrettyPrint re-indents the output of jsonnet and
+	// starlark templates. See TemplatePrettyPrint.
+	PrettyPrint bool
+
+	// DedupDocuments removes duplicate rendered documents.
+	// See TemplateDedupDocuments.
+	DedupDocuments bool
+
+	// StarlarkTraceLimit records a bounded execution trace of
+	// starlark templates. See TemplateStarlarkTrace.
+	StarlarkTraceLimit int
+
+	// EmitProvenance appends a provenance comment. See
+	// TemplateEmitProvenance.
+	EmitProvenance bool
+
+	// CollapseBlankLines collapses runs of blank lines. See
+	// TemplateCollapseBlankLines.
+	CollapseBlankLines bool
+
+	// EmptyConfig sets how empty configuration files are
+	// converted. See TemplateEmptyConfig.
+	EmptyConfig EmptyConfigMode
+
+	// RequireTemplate requires yaml configuration files to
+	// be templates. See TemplateRequireTemplate.
+	RequireTemplate bool
+
+	// FallbackConfig is returned in place of a failed
+	// conversion. See TemplateFallbackConfig.
+	FallbackConfig string
+
+	// CommandPatterns lists the patterns rendered commands
+	// must not match. See TemplateForbiddenCommands.
+	CommandPatterns []*regexp.Regexp
+
+	// RequireName, DuplicateLoads, DuplicateKeys, Tabs,
+	// RequiredSecrets, KnownKinds, PinnedImages, ForbiddenCommands
+	// and UnknownFields set the mode of the corresponding checks.
+	// See TemplateRequireName, TemplateDuplicateLoads,
+	// TemplateDuplicateKeys, TemplateTabs, TemplateRequiredSecrets,
+	// TemplateKnownKinds, TemplatePinnedImages,
+	// TemplateForbiddenCommands and TemplateUnknownFields.
+	RequireName       CheckMode
+	DuplicateLoads    CheckMode
+	DuplicateKeys     CheckMode
+	Tabs              CheckMode
+	RequiredSecrets   CheckMode
+	KnownKinds        CheckMode
+	PinnedImages      CheckMode
+	ForbiddenCommands CheckMode
+	UnknownFields     CheckMode
+}
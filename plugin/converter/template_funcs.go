@@ -0,0 +1,268 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !oss
+
+package converter
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	templating "text/template"
+	"time"
+
+	"github.com/drone/funcmap"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultFuncs are the functions available to yaml templates
+// when no functions are configured for the namespace.
+var defaultFuncs = func() templating.FuncMap {
+	funcs := templating.FuncMap{}
+	for name, fn := range funcmap.SafeFuncs {
+		funcs[name] = fn
+	}
+	funcs["toYaml"] = toYaml
+	funcs["envBlock"] = envBlock
+	funcs["redact"] = redact
+	return funcs
+}()
+
+// randomChars are the characters used by randAlphaNum.
+const randomChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// clockFuncs returns the time and random functions, which use the
+// clock and a random source initialized with the seed, so that the
+// output is reproducible when the clock and seed are fixed.
+func clockFuncs(now func() time.Time, seed int64) templating.FuncMap {
+	r := rand.New(rand.NewSource(seed))
+	return templating.FuncMap{
+		"now": now,
+		"randInt": func(min, max int) int {
+			if max <= min {
+				return min
+			}
+			return min + r.Intn(max-min)
+		},
+		"randAlphaNum": func(n int) string {
+			b := make([]byte, n)
+			for i := range b {
+				b[i] = randomChars[r.Intn(len(randomChars))]
+			}
+			return string(b)
+		},
+	}
+}
+
+// default number of characters retained by redact.
+const redactPrefix = 4
+
+// redactMask replaces the redacted characters. The mask has a
+// fixed length so the length of the value is not revealed.
+const redactMask = "****"
+
+// redact masks the value for use in human-readable fields,
+// retaining the first characters (4 by default). Values that are
+// not longer than the retained prefix are masked entirely.
+func redact(v interface{}, keep ...int) string {
+	n := redactPrefix
+	if len(keep) != 0 && keep[0] >= 0 {
+		n = keep[0]
+	}
+	s := []rune(fmt.Sprint(v))
+	if v == nil || len(s) <= n {
+		return redactMask
+	}
+	return string(s[:n]) + redactMask
+}
+
+// toYaml serializes the value to yaml. Map keys are sorted
+// to ensure the output is deterministic.
+func toYaml(v interface{}) (string, error) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// envBlock serializes the map to an environment block with
+// the variables sorted by name. The block is optionally
+// indented by the given number of spaces.
+func envBlock(v interface{}, indent ...int) (string, error) {
+	env := map[string]string{}
+	switch m := v.(type) {
+	case nil:
+	case map[string]string:
+		for key, val := range m {
+			env[key] = val
+		}
+	case map[string]interface{}:
+		for key, val := range m {
+			env[key] = fmt.Sprint(val)
+		}
+	case map[interface{}]interface{}:
+		for key, val := range m {
+			env[fmt.Sprint(key)] = fmt.Sprint(val)
+		}
+	default:
+		return "", fmt.Errorf("envBlock: cannot serialize %T", v)
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	prefix := ""
+	if len(indent) != 0 {
+		prefix = strings.Repeat(" ", indent[0])
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix + "environment:")
+	for _, key := range keys {
+		out, err := yaml.Marshal(map[string]string{key: env[key]})
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("\n" + prefix + "  " + strings.TrimSuffix(string(out), "\n"))
+	}
+	return b.String(), nil
+}
+
+// defaultLocale is the locale used by the formatting functions
+// when no locale is configured.
+const defaultLocale = "en-US"
+
+// locale defines the formatting conventions of a locale.
+type locale struct {
+	decimal string // decimal separator
+	group   string // digit group separator
+	date    string // date layout
+}
+
+// locales lists the locales supported by the formatting
+// functions.
+var locales = map[string]locale{
+	"en-US": {decimal: ".", group: ",", date: "01/02/2006"},
+	"en-GB": {decimal: ".", group: ",", date: "02/01/2006"},
+	"de-DE": {decimal: ",", group: ".", date: "02.01.2006"},
+	"fr-FR": {decimal: ",", group: "\u202f", date: "02/01/2006"},
+	"ru-RU": {decimal: ",", group: "\u00a0", date: "02.01.2006"},
+}
+
+// localeFuncs returns the date and number formatting functions
+// for the locale. The default locale is used when the name is
+// empty, and an error is returned for unsupported locales.
+func localeFuncs(name string) (templating.FuncMap, error) {
+	if name == "" {
+		name = defaultLocale
+	}
+	l, ok := locales[name]
+	if !ok {
+		return nil, fmt.Errorf("template converter: unsupported locale %q", name)
+	}
+	return templating.FuncMap{
+		"formatDate": func(v interface{}) (string, error) {
+			return formatDate(l, v)
+		},
+		"formatNumber": func(v interface{}, decimals ...int) (string, error) {
+			return formatNumber(l, v, decimals...)
+		},
+	}, nil
+}
+
+// formatDate formats the date using the locale date layout.
+// The date is a time, a unix timestamp in seconds, or a string
+// in RFC 3339 format.
+func formatDate(l locale, v interface{}) (string, error) {
+	var t time.Time
+	switch d := v.(type) {
+	case time.Time:
+		t = d
+	case int:
+		t = time.Unix(int64(d), 0).UTC()
+	case int64:
+		t = time.Unix(d, 0).UTC()
+	case string:
+		parsed, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return "", fmt.Errorf("formatDate: %w", err)
+		}
+		t = parsed
+	default:
+		return "", fmt.Errorf("formatDate: cannot format %T", v)
+	}
+	return t.Format(l.date), nil
+}
+
+// formatNumber formats the number using the locale decimal and
+// digit group separators, with the given number of decimals (0
+// by default).
+func formatNumber(l locale, v interface{}, decimals ...int) (string, error) {
+	var f float64
+	switch n := v.(type) {
+	case int:
+		f = float64(n)
+	case int64:
+		f = float64(n)
+	case uint64:
+		f = float64(n)
+	case float64:
+		f = n
+	case string:
+		parsed, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return "", fmt.Errorf("formatNumber: %w", err)
+		}
+		f = parsed
+	default:
+		return "", fmt.Errorf("formatNumber: cannot format %T", v)
+	}
+
+	precision := 0
+	if len(decimals) != 0 && decimals[0] > 0 {
+		precision = decimals[0]
+	}
+	s := strconv.FormatFloat(f, 'f', precision, 64)
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction := s, ""
+	if i := strings.Index(s, "."); i != -1 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range integer {
+		if i != 0 && (len(integer)-i)%3 == 0 {
+			b.WriteString(l.group)
+		}
+		b.WriteRune(c)
+	}
+	if fraction != "" {
+		b.WriteString(l.decimal)
+		b.WriteString(fraction)
+	}
+	return b.String(), nil
+}
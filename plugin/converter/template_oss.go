@@ -18,11 +18,227 @@ package converter
 
 import (
 	"context"
+	"regexp"
+	templating "text/template"
+	"time"
 
 	"github.com/drone/drone/core"
 )
 
-func Template(templateStore core.TemplateStore, stepLimit uint64, sizeLimit uint64) core.ConvertService {
+// TemplateOption configures the template conversion plugin.
+type TemplateOption func(*templatePlugin)
+
+func TemplateAllowRunners(allow func(repo *core.Repository) []string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateMaxInclusionDepth(n int) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateExtensionSearchOrder(order []string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateFuncs(resolve func(namespace string) templating.FuncMap) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateFileService(fileService core.FileService) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateOrgResolver(resolve func(namespace string) (map[string]interface{}, error)) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateReservedKeys(keys []string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateRegistryMirror(mirror string, table map[string]string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateCache(size int, ttl time.Duration) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateYamlOnly(restricted func(repo *core.Repository) bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateRequireName(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateParamsSchema(resolve func(template *core.Template) *ParamsSchema) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateEmptyConfig(mode EmptyConfigMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateMaxTotalSteps(n int) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateFallbackConfig(data string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateInputAtTopLevel(enabled bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateDuplicateLoads(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateKnownKinds(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateNameNormalizer(normalize func(name string) string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateMaxNestingDepth(n int) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateRequireTemplate(required bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateFeatureFlags(resolve func(repo *core.Repository) func(flag string) bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateCanonicalize(order KeyOrder) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateEnabledEngines(engines []string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateLocale(locale string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateCollapseBlankLines(enabled bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplatePinnedImages(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateUnknownFields(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateLoadRewriter(rewrite func(ctx context.Context, repo *core.Repository, load string) (string, error)) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateRequiredSecrets(mode CheckMode, exists func(ctx context.Context, repo *core.Repository, name string) (bool, error)) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateEngineHints(enabled bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateLinter(lint LintFunc) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateNegativeCache(size int, ttl time.Duration) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplatePrettyPrint(enabled bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateEmitProvenance(enabled bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateWithStoreRetry(attempts int, backoff time.Duration) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateNamePattern(pattern *regexp.Regexp) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateClock(now func() time.Time) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateRandSeed(seed func() int64) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateForbiddenCommands(mode CheckMode, patterns []*regexp.Regexp) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateDedupDocuments(enabled bool) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateServer(url, version string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateValues(name string) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateMaxDataSize(size int) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateSeparatorStyle(style SeparatorStyle) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateDocumentTransform(transform func(ctx context.Context, repo *core.Repository, doc string, rendered bool) (string, error)) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateDuplicateKeys(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateTabs(mode CheckMode) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateCacheBackend(cache Cache) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateStarlarkTrace(limit int) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func TemplateMaxComplexity(n int) TemplateOption {
+	return func(*templatePlugin) {}
+}
+
+func Template(templateStore core.TemplateStore, stepLimit uint64, sizeLimit uint64, opts ...TemplateOption) core.ConvertService {
+	return &templatePlugin{
+		templateStore: templateStore,
+	}
+}
+
+func TemplateWithConfig(templateStore core.TemplateStore, config TemplateConfig, opts ...TemplateOption) core.ConvertService {
 	return &templatePlugin{
 		templateStore: templateStore,
 	}
@@ -35,3 +251,19 @@ type templatePlugin struct {
 func (p *templatePlugin) Convert(ctx context.Context, req *core.ConvertArgs) (*core.Config, error) {
 	return nil, nil
 }
+
+func (p *templatePlugin) Preview(ctx context.Context, req *core.ConvertArgs, opts ...PreviewOption) (*core.Config, error) {
+	return nil, nil
+}
+
+func (p *templatePlugin) Diff(ctx context.Context, before, after *core.ConvertArgs) (string, error) {
+	return "", nil
+}
+
+func (p *templatePlugin) RenderByName(ctx context.Context, name, namespace string, data map[string]interface{}, req *core.ConvertArgs) (string, error) {
+	return "", nil
+}
+
+func (p *templatePlugin) ConvertWithInfo(ctx context.Context, req *core.ConvertArgs) (*core.Config, *ConvertInfo, error) {
+	return nil, nil, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !oss
+
+package converter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drone/drone/core"
+)
+
+// negative cache key pattern, comprised of the template
+// namespace and name. The prefix prevents collisions with
+// converted configuration files in a shared cache.
+const negativeKeyf = "template-not-found|%s|%s"
+
+// NegativeCache returns a template store that caches templates
+// not found in the base store for the duration of the ttl, which
+// prevents repeated references to a missing template from hitting
+// the datastore. The cached result is invalidated when a template
+// of that name is created or updated using the returned store.
+func NegativeCache(base core.TemplateStore, size int, ttl time.Duration) core.TemplateStore {
+	return newNegativeCache(base, MemoryCache(size), ttl)
+}
+
+// newNegativeCache returns a template store that caches
+// templates not found using the cache backend.
+func newNegativeCache(base core.TemplateStore, cache Cache, ttl time.Duration) core.TemplateStore {
+	return &negativeCache{
+		TemplateStore: base,
+		cache:         cache,
+		ttl:           ttl,
+		now:           time.Now,
+	}
+}
+
+type negativeCache struct {
+	core.TemplateStore
+	cache Cache
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+func (s *negativeCache) FindName(ctx context.Context, name, namespace string) (*core.Template, error) {
+	key := fmt.Sprintf(negativeKeyf, namespace, name)
+	if v, ok := s.cache.Get(ctx, key); ok {
+		var expires time.Time
+		if expires.UnmarshalText(v) == nil && s.now().Before(expires) {
+			return nil, sql.ErrNoRows
+		}
+		s.cache.Delete(ctx, key)
+	}
+	template, err := s.TemplateStore.FindName(ctx, name, namespace)
+	if err == sql.ErrNoRows {
+		if v, err := s.now().Add(s.ttl).MarshalText(); err == nil {
+			s.cache.Set(ctx, key, v, s.ttl)
+		}
+	}
+	return template, err
+}
+
+func (s *negativeCache) Create(ctx context.Context, template *core.Template) error {
+	err := s.TemplateStore.Create(ctx, template)
+	s.invalidate(ctx, template)
+	return err
+}
+
+func (s *negativeCache) Update(ctx context.Context, template *core.Template) error {
+	err := s.TemplateStore.Update(ctx, template)
+	s.invalidate(ctx, template)
+	return err
+}
+
+// invalidate removes the template from the cache of
+// templates not found.
+func (s *negativeCache) invalidate(ctx context.Context, template *core.Template) {
+	s.cache.Delete(ctx, fmt.Sprintf(negativeKeyf, template.Namespace, template.Name))
+}
+
+// retryStore returns a template store that retries finding a
+// template when the base store fails with a timeout.
+func retryStore(base core.TemplateStore, attempts int, backoff time.Duration) core.TemplateStore {
+	return &retrier{
+		TemplateStore: base,
+		attempts:      attempts,
+		backoff:       backoff,
+	}
+}
+
+type retrier struct {
+	core.TemplateStore
+	attempts int
+	backoff  time.Duration
+}
+
+func (s *retrier) FindName(ctx context.Context, name, namespace string) (*core.Template, error) {
+	backoff := s.backoff
+	for i := 1; ; i++ {
+		template, err := s.TemplateStore.FindName(ctx, name, namespace)
+		if err == nil || i >= s.attempts || !retryable(err) || ctx.Err() != nil {
+			return template, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true if the datastore error is a timeout,
+// in which case the request may succeed if retried.
+func retryable(err error) bool {
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
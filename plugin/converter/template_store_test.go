@@ -0,0 +1,195 @@
+// Copyright 2019 Drone IO, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !oss
+
+package converter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drone/drone/core"
+	"github.com/drone/drone/mock"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestNegativeCache(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	base := mock.NewMockTemplateStore(controller)
+	base.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").Return(nil, sql.ErrNoRows)
+
+	store := NegativeCache(base, 10, time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := store.FindName(noContext, "plugin.yaml", "octocat"); err != sql.ErrNoRows {
+			t.Errorf("Want sql.ErrNoRows got %v", err)
+		}
+	}
+}
+
+func TestNegativeCacheZeroSize(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	// a cache without entries passes each lookup to the
+	// base store.
+	base := mock.NewMockTemplateStore(controller)
+	base.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").Return(nil, sql.ErrNoRows).Times(2)
+
+	store := NegativeCache(base, 0, time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := store.FindName(noContext, "plugin.yaml", "octocat"); err != sql.ErrNoRows {
+			t.Errorf("Want sql.ErrNoRows got %v", err)
+		}
+	}
+}
+
+func TestNegativeCacheExpired(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	base := mock.NewMockTemplateStore(controller)
+	base.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").Return(nil, sql.ErrNoRows).Times(2)
+
+	now := time.Now()
+	store := NegativeCache(base, 10, time.Minute).(*negativeCache)
+	store.now = func() time.Time { return now }
+
+	store.FindName(noContext, "plugin.yaml", "octocat")
+	store.FindName(noContext, "plugin.yaml", "octocat")
+
+	now = now.Add(time.Minute)
+	store.FindName(noContext, "plugin.yaml", "octocat")
+}
+
+func TestNegativeCacheCreate(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	base := mock.NewMockTemplateStore(controller)
+	gomock.InOrder(
+		base.EXPECT().FindName(gomock.Any(), template.Name, template.Namespace).Return(nil, sql.ErrNoRows),
+		base.EXPECT().Create(gomock.Any(), template).Return(nil),
+		base.EXPECT().FindName(gomock.Any(), template.Name, template.Namespace).Return(template, nil),
+	)
+
+	store := NegativeCache(base, 10, time.Minute)
+	if _, err := store.FindName(noContext, template.Name, template.Namespace); err != sql.ErrNoRows {
+		t.Errorf("Want sql.ErrNoRows got %v", err)
+	}
+	if err := store.Create(noContext, template); err != nil {
+		t.Error(err)
+	}
+	got, err := store.FindName(noContext, template.Name, template.Namespace)
+	if err != nil {
+		t.Error(err)
+	} else if got != template {
+		t.Errorf("Want template found after create")
+	}
+}
+
+// timeoutError is a datastore error caused by a timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryStore(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	base := mock.NewMockTemplateStore(controller)
+	gomock.InOrder(
+		base.EXPECT().FindName(gomock.Any(), template.Name, template.Namespace).Return(nil, timeoutError{}),
+		base.EXPECT().FindName(gomock.Any(), template.Name, template.Namespace).Return(nil, context.DeadlineExceeded),
+		base.EXPECT().FindName(gomock.Any(), template.Name, template.Namespace).Return(template, nil),
+	)
+
+	store := retryStore(base, 3, time.Millisecond)
+	got, err := store.FindName(noContext, template.Name, template.Namespace)
+	if err != nil {
+		t.Error(err)
+	} else if got != template {
+		t.Errorf("Want template found after retry")
+	}
+}
+
+func TestRetryStoreAttempts(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	base := mock.NewMockTemplateStore(controller)
+	base.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").Return(nil, timeoutError{}).Times(2)
+
+	store := retryStore(base, 2, time.Millisecond)
+	if _, err := store.FindName(noContext, "plugin.yaml", "octocat"); err != (timeoutError{}) {
+		t.Errorf("Want timeout error after the last attempt, got %v", err)
+	}
+}
+
+func TestRetryStoreNotRetryable(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	base := mock.NewMockTemplateStore(controller)
+	base.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").Return(nil, sql.ErrNoRows)
+	base.EXPECT().FindName(gomock.Any(), "base.yaml", "octocat").Return(nil, errors.New("pq: syntax error"))
+
+	store := retryStore(base, 3, time.Millisecond)
+	if _, err := store.FindName(noContext, "plugin.yaml", "octocat"); err != sql.ErrNoRows {
+		t.Errorf("Want sql.ErrNoRows got %v", err)
+	}
+	if _, err := store.FindName(noContext, "base.yaml", "octocat"); err == nil {
+		t.Errorf("Want error not retried")
+	}
+}
+
+func TestRetryStoreContext(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	ctx, cancel := context.WithCancel(noContext)
+
+	base := mock.NewMockTemplateStore(controller)
+	base.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").DoAndReturn(
+		func(context.Context, string, string) (*core.Template, error) {
+			cancel()
+			return nil, timeoutError{}
+		},
+	)
+
+	store := retryStore(base, 3, time.Hour)
+	if _, err := store.FindName(ctx, "plugin.yaml", "octocat"); err != (timeoutError{}) {
+		t.Errorf("Want timeout error when the context is canceled, got %v", err)
+	}
+}
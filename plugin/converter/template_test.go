@@ -12,18 +12,29 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// +build !oss
+
 package converter
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"regexp"
 	"runtime"
 	"strings"
 	"testing"
+	templating "text/template"
+	"time"
 
 	"github.com/drone/drone/core"
 	"github.com/drone/drone/mock"
+	"github.com/drone/go-scm/scm"
 
 	"github.com/golang/mock/gomock"
 )
@@ -174,7 +185,7 @@ func TestTemplatePluginConvertDroneFileYamlExtensions(t *testing.T) {
 			}
 
 			_, err = plugin.Convert(noContext, req)
-			if err != nil && err != dummyErr {
+			if err != nil && !errors.Is(err, dummyErr) {
 				t.Error(err)
 			}
 			if err == nil {
@@ -551,3 +562,5057 @@ func TestTemplatePluginConvertYamlWithComment(t *testing.T) {
 		t.Errorf("Want %q got %q", want, got)
 	}
 }
+
+func TestTemplatePluginConvertAllowRunners(t *testing.T) {
+	templateArgs, err := ioutil.ReadFile("testdata/yaml.template.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	beforeInput, err := ioutil.ReadFile("testdata/yaml.input.yml")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := []struct {
+		allow []string
+		valid bool
+	}{
+		{allow: nil, valid: true},
+		{allow: []string{"docker"}, valid: true},
+		{allow: []string{"exec", "docker"}, valid: true},
+		{allow: []string{"kubernetes"}, valid: false},
+		{allow: []string{"docker/windows"}, valid: false},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: string(templateArgs),
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      string(beforeInput),
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		allow := test.allow
+		plugin := Template(templates, 0, 0,
+			TemplateAllowRunners(func(repo *core.Repository) []string {
+				return allow
+			}),
+		)
+		config, err := plugin.Convert(noContext, req)
+		if test.valid && err != nil {
+			t.Errorf("Want runner allowed for %v, got error %s", test.allow, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Want runner disallowed for %v", test.allow)
+		}
+		if !test.valid && config != nil {
+			t.Errorf("Want nil configuration for disallowed runner")
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplateAllowedRunner(t *testing.T) {
+	tests := []struct {
+		runner []string
+		allow  []string
+		want   bool
+	}{
+		{runner: []string{"docker"}, allow: []string{"docker"}, want: true},
+		{runner: []string{"docker", "linux", "arm64"}, allow: []string{"docker"}, want: true},
+		{runner: []string{"docker", "linux", "arm64"}, allow: []string{"docker/linux"}, want: true},
+		{runner: []string{"docker", "linux", "arm64"}, allow: []string{"docker/linux/amd64"}, want: false},
+		{runner: []string{"docker"}, allow: []string{"docker/linux"}, want: false},
+		{runner: []string{"exec"}, allow: []string{"docker", "kubernetes"}, want: false},
+	}
+	for _, test := range tests {
+		if got := allowedRunner(test.runner, test.allow); got != test.want {
+			t.Errorf("Want allowed %v for runner %v and allow list %v", test.want, test.runner, test.allow)
+		}
+	}
+}
+
+func TestTemplatePluginConvertNested(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: a.yaml\n",
+		},
+	}
+
+	templates := map[string]*core.Template{
+		"a.yaml": {Name: "a.yaml", Data: "kind: template\nload: b.yaml\n", Namespace: "octocat"},
+		"b.yaml": {Name: "b.yaml", Data: "kind: template\nload: c.yaml\n", Namespace: "octocat"},
+		"c.yaml": {Name: "c.yaml", Data: "kind: pipeline\nname: default\n", Namespace: "octocat"},
+	}
+
+	tests := []struct {
+		depth int
+		err   string
+	}{
+		{depth: 0},
+		{depth: 3},
+		{depth: 2, err: "template converter: maximum inclusion depth of 2 exceeded: a.yaml -> b.yaml -> c.yaml"},
+	}
+
+	for _, test := range tests {
+		controller := gomock.NewController(t)
+
+		store := mock.NewMockTemplateStore(controller)
+		store.EXPECT().FindName(gomock.Any(), gomock.Any(), req.Repo.Namespace).DoAndReturn(
+			func(_ context.Context, name, _ string) (*core.Template, error) {
+				return templates[name], nil
+			},
+		).AnyTimes()
+
+		plugin := Template(store, 0, 0, TemplateMaxInclusionDepth(test.depth))
+		config, err := plugin.Convert(noContext, req)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q for depth %d", test.err, test.depth)
+			} else if got, want := err.Error(), test.err; got != want {
+				t.Errorf("Want error %q got %q", want, got)
+			}
+		} else if err != nil {
+			t.Error(err)
+		} else if got, want := config.Data, templates["c.yaml"].Data; got != want {
+			t.Errorf("Want %q got %q", want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertNestedCycle(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: a.yaml\n",
+		},
+	}
+
+	templates := map[string]*core.Template{
+		"a.yaml": {Name: "a.yaml", Data: "kind: template\nload: b.yaml\n", Namespace: "octocat"},
+		"b.yaml": {Name: "b.yaml", Data: "kind: template\nload: a.yaml\n", Namespace: "octocat"},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	store := mock.NewMockTemplateStore(controller)
+	store.EXPECT().FindName(gomock.Any(), gomock.Any(), req.Repo.Namespace).DoAndReturn(
+		func(_ context.Context, name, _ string) (*core.Template, error) {
+			return templates[name], nil
+		},
+	).Times(2)
+
+	plugin := Template(store, 0, 0)
+	_, err := plugin.Convert(noContext, req)
+	if err == nil {
+		t.Errorf("Want inclusion cycle error")
+		return
+	}
+	if got, want := err.Error(), "template converter: inclusion cycle detected: a.yaml -> b.yaml -> a.yaml"; got != want {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertErrorClassification(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	t.Run("SyntaxError", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: {{ .input.name ",
+			Namespace: "octocat",
+		}
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		_, err := Template(templates, 0, 0).Convert(noContext, req)
+		var userErr *UserError
+		if !errors.As(err, &userErr) {
+			t.Errorf("Want UserError for template syntax error, got %v", err)
+		}
+		var serverErr *ServerError
+		if errors.As(err, &serverErr) {
+			t.Errorf("Want syntax error not classified as ServerError")
+		}
+	})
+
+	t.Run("StoreTimeout", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", req.Repo.Namespace).Return(nil, context.DeadlineExceeded)
+
+		_, err := Template(templates, 0, 0).Convert(noContext, req)
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			t.Errorf("Want ServerError for store timeout, got %v", err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Want store error to be wrapped")
+		}
+		var userErr *UserError
+		if errors.As(err, &userErr) {
+			t.Errorf("Want store timeout not classified as UserError")
+		}
+	})
+}
+
+func TestTemplatePluginConvertCommitAuthor(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "notify.yaml",
+			data: "kind: pipeline\nname: notify\nenvironment:\n  MESSAGE: {{ .build.message }}\n  AUTHOR: {{ .build.author }} <{{ .build.author_email }}>\n  COMMITTER: {{ .build.author_name }} ({{ .build.author_login }})\n",
+		},
+		{
+			name: "notify.star",
+			data: "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": \"notify\", \"environment\": {\"MESSAGE\": ctx.build.message, \"AUTHOR\": \"%s <%s>\" % (ctx.build.author, ctx.build.author_email), \"COMMITTER\": \"%s (%s)\" % (ctx.build.author_name, ctx.build.author_login)}}\n",
+		},
+		{
+			name: "notify.jsonnet",
+			data: "{kind: 'pipeline', name: 'notify', environment: {MESSAGE: std.extVar('build.message'), AUTHOR: std.extVar('build.author') + ' <' + std.extVar('build.author_email') + '>', COMMITTER: std.extVar('build.author_name') + ' (' + std.extVar('build.author_login') + ')'}}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &core.ConvertArgs{
+				Build: &core.Build{
+					After:       "3d21ec53a331a6f037a91c368710b99387d012c1",
+					Message:     "update readme",
+					Author:      "octocat",
+					AuthorName:  "The Octocat",
+					AuthorEmail: "octocat@github.com",
+				},
+				Repo: &core.Repository{
+					Slug:      "octocat/hello-world",
+					Config:    ".drone.yml",
+					Namespace: "octocat",
+				},
+				Config: &core.Config{
+					Data: "kind: template\nload: " + test.name + "\n",
+				},
+			}
+
+			template := &core.Template{
+				Name:      test.name,
+				Data:      test.data,
+				Namespace: "octocat",
+			}
+
+			controller := gomock.NewController(t)
+			defer controller.Finish()
+
+			templates := mock.NewMockTemplateStore(controller)
+			templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+			config, err := Template(templates, 0, 0).Convert(noContext, req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for _, want := range []string{"update readme", "The Octocat <octocat@github.com>", "The Octocat (octocat)"} {
+				if !strings.Contains(config.Data, want) {
+					t.Errorf("Want %q in rendered config %q", want, config.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplatePluginConvertBuildFieldNames(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			Event:  core.EventPromote,
+			Ref:    "refs/heads/master",
+			Target: "master",
+			After:  "3d21ec53a331a6f037a91c368710b99387d012c1",
+			Author: "octocat",
+			Deploy: "production",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	// the field names of the build are retained for
+	// templates written before the keys were added.
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "event: {{ .build.Event }}\nref: {{ .build.Ref }}\ntarget: {{ .build.Target }}\nafter: {{ .build.After }}\nauthor: {{ .build.Author }}\ndeploy: {{ .build.Deploy }}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	config, err := Template(templates, 0, 0).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := "event: promote\nref: refs/heads/master\ntarget: master\nafter: 3d21ec53a331a6f037a91c368710b99387d012c1\nauthor: octocat\ndeploy: production\n"
+	if got := config.Data; got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertCommitAuthorMissing(t *testing.T) {
+	req := &core.ConvertArgs{
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: notify.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "notify.yaml",
+		Data:      "message: '{{ .build.message }}'\nauthor: '{{ .build.author }}'\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	config, err := Template(templates, 0, 0).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want, got := "message: ''\nauthor: ''\n", config.Data; want != got {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertExtensionSearchOrder(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: base\n",
+		},
+	}
+
+	templates := map[string]*core.Template{
+		"base.yaml":    {Name: "base.yaml", Data: "kind: pipeline\nname: yaml\n", Namespace: "octocat"},
+		"base.jsonnet": {Name: "base.jsonnet", Data: "{kind: 'pipeline', name: 'jsonnet'}", Namespace: "octocat"},
+	}
+
+	tests := []struct {
+		order []string
+		want  string
+	}{
+		{order: nil, want: "name: yaml"},
+		{order: []string{"yaml", "jsonnet"}, want: "name: yaml"},
+		{order: []string{"jsonnet", "yaml"}, want: `"name": "jsonnet"`},
+		{order: []string{".star", ".jsonnet", ".yaml"}, want: `"name": "jsonnet"`},
+	}
+
+	for _, test := range tests {
+		controller := gomock.NewController(t)
+
+		store := mock.NewMockTemplateStore(controller)
+		store.EXPECT().FindName(gomock.Any(), gomock.Any(), req.Repo.Namespace).DoAndReturn(
+			func(_ context.Context, name, _ string) (*core.Template, error) {
+				if template, ok := templates[name]; ok {
+					return template, nil
+				}
+				return nil, sql.ErrNoRows
+			},
+		).AnyTimes()
+
+		plugin := Template(store, 0, 0, TemplateExtensionSearchOrder(test.order))
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if !strings.Contains(config.Data, test.want) {
+			t.Errorf("Want %q in rendered config %q for search order %v", test.want, config.Data, test.order)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertExtensionSearchNotFound(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: base\n",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	store := mock.NewMockTemplateStore(controller)
+	store.EXPECT().FindName(gomock.Any(), "base.yaml", req.Repo.Namespace).Return(nil, sql.ErrNoRows)
+	store.EXPECT().FindName(gomock.Any(), "base.jsonnet", req.Repo.Namespace).Return(nil, sql.ErrNoRows)
+
+	plugin := Template(store, 0, 0, TemplateExtensionSearchOrder([]string{"yaml", "jsonnet"}))
+	_, err := plugin.Convert(noContext, req)
+	if !errors.Is(err, errTemplateNotFound) {
+		t.Errorf("Want template not found error, got %v", err)
+	}
+}
+
+func TestTemplatePluginConvertChecksum(t *testing.T) {
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: {{ .input.name }}\n",
+		Namespace: "octocat",
+	}
+
+	convert := func(name string) (*core.Config, *ConvertInfo) {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\ndata:\n  name: " + name + "\n",
+			},
+		}
+
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0).(InfoConverter)
+		config, info, err := plugin.ConvertWithInfo(noContext, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config, info
+	}
+
+	config, a := convert("default")
+	_, b := convert("default")
+	_, c := convert("changed")
+
+	checksum := sha256.Sum256([]byte(config.Data))
+	if want, got := hex.EncodeToString(checksum[:]), a.Checksum; want != got {
+		t.Errorf("Want checksum %q got %q", want, got)
+	}
+	if a.Checksum != b.Checksum {
+		t.Errorf("Want stable checksum, got %q and %q", a.Checksum, b.Checksum)
+	}
+	if a.Checksum == c.Checksum {
+		t.Errorf("Want checksum to change when the output changes")
+	}
+}
+
+func TestTemplatePluginConvertSkip(t *testing.T) {
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "{{ if .input.docs }}kind: skip{{ else }}kind: pipeline\nname: default{{ end }}\n",
+		Namespace: "octocat",
+	}
+
+	tests := []struct {
+		docs bool
+		skip bool
+	}{
+		{docs: true, skip: true},
+		{docs: false, skip: false},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: fmt.Sprintf("kind: template\nload: plugin.yaml\ndata:\n  docs: %t\n", test.docs),
+			},
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0).(InfoConverter)
+		config, info, err := plugin.ConvertWithInfo(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else {
+			if got, want := info.Skip, test.skip; got != want {
+				t.Errorf("Want skip %v got %v", want, got)
+			}
+			if got, want := config.Kind == core.ConfigKindSkip, test.skip; got != want {
+				t.Errorf("Want skip kind %v got kind %q", want, config.Kind)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertNamespaceFuncs(t *testing.T) {
+	resolve := func(namespace string) templating.FuncMap {
+		switch namespace {
+		case "untrusted":
+			return templating.FuncMap{}
+		case "custom":
+			return templating.FuncMap{
+				"shout": func(s string) string { return strings.ToUpper(s) + "!" },
+			}
+		case "flagged":
+			return templating.FuncMap{
+				"flag": nil,
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		namespace string
+		data      string
+		want      string
+		err       bool
+	}{
+		{namespace: "trusted", data: "name: {{ upper .input.name }}\n", want: "name: DEFAULT\n"},
+		{namespace: "untrusted", data: "name: {{ upper .input.name }}\n", err: true},
+		{namespace: "custom", data: "name: {{ shout .input.name }}\n", want: "name: DEFAULT!\n"},
+		{namespace: "custom", data: "name: {{ upper .input.name }}\n", err: true},
+		// helpers are only available to a resolved namespace
+		// if the resolved functions name the helper.
+		{namespace: "trusted", data: "name: {{ if flag \"beta\" }}beta{{ else }}{{ .input.name }}{{ end }}\n", want: "name: default\n"},
+		{namespace: "untrusted", data: "name: {{ now }}\n", err: true},
+		{namespace: "untrusted", data: "name: {{ randInt 1 10 }}\n", err: true},
+		{namespace: "untrusted", data: "name: {{ if flag \"beta\" }}beta{{ end }}\n", err: true},
+		{namespace: "flagged", data: "name: {{ if flag \"beta\" }}beta{{ else }}{{ .input.name }}{{ end }}\n", want: "name: default\n"},
+		{namespace: "flagged", data: "name: {{ now }}\n", err: true},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      test.namespace + "/hello-world",
+				Config:    ".drone.yml",
+				Namespace: test.namespace,
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\ndata:\n  name: default\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.data,
+			Namespace: test.namespace,
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateFuncs(resolve))
+		config, err := plugin.Convert(noContext, req)
+		if test.err {
+			if err == nil {
+				t.Errorf("Want error rendering %q in namespace %s", test.data, test.namespace)
+			}
+		} else if err != nil {
+			t.Error(err)
+		} else if got, want := config.Data, test.want; got != want {
+			t.Errorf("Want %q got %q", want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertInclude(t *testing.T) {
+	req := &core.ConvertArgs{
+		User: &core.User{Login: "octocat"},
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			Ref:   "refs/heads/master",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    "services/api/.drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\ninclude: ../common/pipeline.yaml\ndata:\n  name: api\n",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	file := &core.File{
+		Data: []byte("kind: pipeline\nname: {{ .input.name }}\n"),
+	}
+
+	files := mock.NewMockFileService(controller)
+	files.EXPECT().Find(gomock.Any(), req.User, req.Repo.Slug, req.Build.After, req.Build.Ref, "services/common/pipeline.yaml").Return(file, nil)
+
+	plugin := Template(nil, 0, 0, TemplateFileService(files))
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want, got := "kind: pipeline\nname: api\n", config.Data; want != got {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertIncludeErrors(t *testing.T) {
+	req := &core.ConvertArgs{
+		User: &core.User{Login: "octocat"},
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			Ref:   "refs/heads/master",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\ninclude: pipeline.yaml\n",
+		},
+	}
+
+	tests := []struct {
+		err    error
+		server bool
+	}{
+		// a missing file is a mistake in the configuration.
+		{err: scm.ErrNotFound, server: false},
+		// other errors are caused by the provider.
+		{err: context.DeadlineExceeded, server: true},
+	}
+
+	for _, test := range tests {
+		controller := gomock.NewController(t)
+
+		files := mock.NewMockFileService(controller)
+		files.EXPECT().Find(gomock.Any(), req.User, req.Repo.Slug, req.Build.After, req.Build.Ref, "pipeline.yaml").Return(nil, test.err)
+
+		_, err := Template(nil, 0, 0, TemplateFileService(files)).Convert(noContext, req)
+
+		var serverErr *ServerError
+		var userErr *UserError
+		switch {
+		case err == nil:
+			t.Errorf("Want error for file service error %s", test.err)
+		case test.server && !errors.As(err, &serverErr):
+			t.Errorf("Want ServerError for file service error %s, got %v", test.err, err)
+		case !test.server && !errors.As(err, &userErr):
+			t.Errorf("Want UserError for file service error %s, got %v", test.err, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertIncludeTraversal(t *testing.T) {
+	tests := []string{
+		"../../../etc/passwd",
+		"../../..",
+		"/etc/passwd",
+	}
+
+	for _, include := range tests {
+		req := &core.ConvertArgs{
+			User: &core.User{Login: "octocat"},
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    "services/.drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\ninclude: " + include + "\n",
+			},
+		}
+
+		controller := gomock.NewController(t)
+
+		// the file service is not expected to be called
+		files := mock.NewMockFileService(controller)
+
+		plugin := Template(nil, 0, 0, TemplateFileService(files))
+		_, err := plugin.Convert(noContext, req)
+		if err == nil {
+			t.Errorf("Want error including path %q", include)
+		} else if !strings.Contains(err.Error(), "outside the repository") {
+			t.Errorf("Want path traversal error including %q, got %s", include, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplateIncludePath(t *testing.T) {
+	tests := []struct {
+		config, name, want string
+	}{
+		{config: ".drone.yml", name: "base.yaml", want: "base.yaml"},
+		{config: ".drone.yml", name: "ci/base.yaml", want: "ci/base.yaml"},
+		{config: "services/api/.drone.yml", name: "../base.yaml", want: "services/base.yaml"},
+		{config: "services/api/.drone.yml", name: "./base.yaml", want: "services/api/base.yaml"},
+	}
+	for _, test := range tests {
+		got, err := includePath(test.config, test.name)
+		if err != nil {
+			t.Error(err)
+		} else if got != test.want {
+			t.Errorf("Want include path %q got %q", test.want, got)
+		}
+	}
+}
+
+func TestTemplatePluginConvertSortedEnvironment(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\ndata:\n  env:\n    ZONE: b\n    REGION: eu-west-1\n    APP: hello\n    DEBUG: true\n    MIDDLE: m\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n{{ envBlock .input.env }}\nsteps:\n- name: build\n  image: golang\n{{ envBlock .input.env 2 }}\n",
+		Namespace: "octocat",
+	}
+
+	want := `kind: pipeline
+name: default
+environment:
+  APP: hello
+  DEBUG: "true"
+  MIDDLE: m
+  REGION: eu-west-1
+  ZONE: b
+steps:
+- name: build
+  image: golang
+  environment:
+    APP: hello
+    DEBUG: "true"
+    MIDDLE: m
+    REGION: eu-west-1
+    ZONE: b
+`
+
+	for i := 0; i < 10; i++ {
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		config, err := Template(templates, 0, 0).Convert(noContext, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := config.Data; got != want {
+			t.Fatalf("Want %q got %q", want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplateFuncToYaml(t *testing.T) {
+	v := map[string]interface{}{
+		"zone":   "b",
+		"region": "eu-west-1",
+		"app":    map[interface{}]interface{}{"version": 2, "name": "hello"},
+	}
+	want := "app:\n  name: hello\n  version: 2\nregion: eu-west-1\nzone: b"
+	for i := 0; i < 10; i++ {
+		got, err := toYaml(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Want %q got %q", want, got)
+		}
+	}
+}
+
+func TestTemplatePluginPreviewMaxSteps(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: a\n  image: alpine\n- name: b\n  image: alpine\n- name: c\n  image: alpine\n- name: d\n  image: alpine\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	plugin := Template(templates, 0, 0)
+
+	preview, err := plugin.(Previewer).Preview(noContext, req, MaxStepsPerPipeline(2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := "---\n# preview truncated: showing 2 of 4 steps\nkind: pipeline\nname: default\nsteps:\n- name: a\n  image: alpine\n- name: b\n  image: alpine\n"
+	if got := preview.Data; got != want {
+		t.Errorf("Want preview %q got %q", want, got)
+	}
+
+	// a regular conversion must not be truncated.
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := config.Data, template.Data; got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertOrg(t *testing.T) {
+	resolve := func(namespace string) (map[string]interface{}, error) {
+		if namespace != "octocat" {
+			return nil, errors.New("unknown organization")
+		}
+		return map[string]interface{}{"team": "platform", "owner": "mona"}, nil
+	}
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: {{ .org.team }}\n",
+		},
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": ctx.org.team}\n",
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: std.extVar('org.team')}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &core.ConvertArgs{
+				Build: &core.Build{
+					After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+				},
+				Repo: &core.Repository{
+					Slug:      "octocat/hello-world",
+					Config:    ".drone.yml",
+					Namespace: "octocat",
+				},
+				Config: &core.Config{
+					Data: "kind: template\nload: " + test.name + "\n",
+				},
+			}
+
+			template := &core.Template{
+				Name:      test.name,
+				Data:      test.data,
+				Namespace: "octocat",
+			}
+
+			controller := gomock.NewController(t)
+			defer controller.Finish()
+
+			templates := mock.NewMockTemplateStore(controller)
+			templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+			plugin := Template(templates, 0, 0, TemplateOrgResolver(resolve))
+			config, err := plugin.Convert(noContext, req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			docs, err := parseDocuments(config.Data)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got, want := docs[0]["name"], "platform"; got != want {
+				t.Errorf("Want pipeline name %q got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestTemplatePluginConvertOrgError(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	resolveErr := errors.New("directory unavailable")
+	resolve := func(namespace string) (map[string]interface{}, error) {
+		return nil, resolveErr
+	}
+
+	plugin := Template(nil, 0, 0, TemplateOrgResolver(resolve))
+	_, err := plugin.Convert(noContext, req)
+	if !errors.Is(err, resolveErr) {
+		t.Errorf("Want resolver error, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), `namespace "octocat"`) {
+		t.Errorf("Want error to name the namespace, got %s", err)
+	}
+}
+
+func TestTemplatePluginConvertReservedKeys(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{
+			data: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n",
+		},
+		{
+			data: "kind: pipeline\nname: default\nclone:\n  depth: 50\n",
+		},
+		{
+			data: "kind: pipeline\nname: default\nclone:\n  disable: true\n",
+			err:  `template converter: pipeline "default" sets reserved key "clone.disable"`,
+		},
+		{
+			data: "kind: pipeline\nname: default\nnode:\n  pool: fast\n",
+			err:  `template converter: pipeline "default" sets reserved key "node"`,
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateReservedKeys([]string{"clone.disable", "node"}))
+		_, err := plugin.Convert(noContext, req)
+		if test.err == "" && err != nil {
+			t.Errorf("Want no error for %q, got %s", test.data, err)
+		}
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginDiff(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\ndata:\n  image: golang\n",
+		},
+	}
+
+	before := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: {{ .input.image }}\n",
+		Namespace: "octocat",
+	}
+	after := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: {{ .input.image }}:1.17\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	gomock.InOrder(
+		templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", req.Repo.Namespace).Return(before, nil),
+		templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", req.Repo.Namespace).Return(after, nil),
+	)
+
+	plugin := Template(templates, 0, 0).(Differ)
+	diff, err := plugin.Diff(noContext, req, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := `--- before
++++ after
+@@ -2,4 +2,4 @@
+ name: default
+ steps:
+ - name: build
+-  image: golang
++  image: golang:1.17
+`
+	if diff != want {
+		t.Errorf("Want diff %q got %q", want, diff)
+	}
+}
+
+func TestTemplatePluginConvertBuildLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name:   "plugin.yaml",
+			data:   "kind: pipeline\nname: {{ if eq .build.labels.team \"payments\" }}payments{{ else }}default{{ end }}\n",
+			labels: map[string]string{"team": "payments"},
+			want:   "payments",
+		},
+		{
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: {{ if eq (len .build.labels) 0 }}unlabeled{{ else }}labeled{{ end }}\n",
+			want: "unlabeled",
+		},
+		{
+			name:   "plugin.star",
+			data:   "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": ctx.build.labels.get(\"team\", \"default\")}\n",
+			labels: map[string]string{"team": "payments"},
+			want:   "payments",
+		},
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": ctx.build.labels.get(\"team\", \"default\")}\n",
+			want: "default",
+		},
+		{
+			name:   "plugin.jsonnet",
+			data:   "{kind: 'pipeline', name: std.extVar('build.labels.team')}\n",
+			labels: map[string]string{"team": "payments"},
+			want:   "payments",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After:  "3d21ec53a331a6f037a91c368710b99387d012c1",
+				Labels: test.labels,
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		config, err := Template(templates, 0, 0).Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if docs, err := parseDocuments(config.Data); err != nil {
+			t.Error(err)
+		} else if got := docs[0]["name"]; got != test.want {
+			t.Errorf("%s: want pipeline name %q got %q", test.name, test.want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertRegistryMirror(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang:1.17\n- name: cache\n  image: mirror.local/alpine:3\n- name: notify\n  image: plugins/slack\nservices:\n- name: database\n  image: redis\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	plugin := Template(templates, 0, 0,
+		TemplateRegistryMirror("mirror.local/", map[string]string{
+			"plugins/slack": "mirror.local/drone/slack:1",
+		}),
+	)
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := "---\nkind: pipeline\nname: default\nsteps:\n- name: build\n  image: mirror.local/golang:1.17\n- name: cache\n  image: mirror.local/alpine:3\n- name: notify\n  image: mirror.local/drone/slack:1\nservices:\n- name: database\n  image: mirror.local/redis\n"
+	if got := config.Data; got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertRegistryMirrorUnchanged(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: mirror.local/golang:1.17\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	plugin := Template(templates, 0, 0, TemplateRegistryMirror("mirror.local", nil))
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the configuration is not re-encoded if all images
+	// already use the mirror.
+	if got, want := config.Data, template.Data; got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertCache(t *testing.T) {
+	tests := []struct {
+		config  string
+		ttl     time.Duration
+		fetches int
+	}{
+		// the default ttl applies when the template document
+		// does not include a cache directive.
+		{config: "kind: template\nload: plugin.yaml\n", ttl: time.Minute, fetches: 2},
+		// the cache directive overrides the default ttl.
+		{config: "kind: template\nload: plugin.yaml\ncache:\n  ttl: 10m\n", ttl: 10 * time.Minute, fetches: 4},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: test.config,
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: default\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		// the template is fetched when the configuration file
+		// is converted, and when the cached entry is checked for
+		// changes to the template at most once per default ttl.
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(test.fetches)
+
+		now := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+		plugin := Template(templates, 0, 0, TemplateCache(10, time.Minute)).(*templatePlugin)
+
+		for _, elapsed := range []time.Duration{0, test.ttl / 2, test.ttl - time.Second, test.ttl} {
+			plugin.now = func() time.Time { return now.Add(elapsed) }
+			config, err := plugin.Convert(noContext, req)
+			if err != nil {
+				t.Error(err)
+			} else if got, want := config.Data, template.Data; got != want {
+				t.Errorf("Want %q got %q", want, got)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertCacheKey(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			Event:  core.EventPromote,
+			After:  "3d21ec53a331a6f037a91c368710b99387d012c1",
+			Deploy: "staging",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: deploy-{{ .build.environment }}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).AnyTimes()
+
+	// promoting the same commit to a different environment
+	// does not return the cached configuration.
+	plugin := Template(templates, 0, 0, TemplateCache(10, time.Minute))
+	for _, deploy := range []string{"staging", "production", "staging"} {
+		req.Build.Deploy = deploy
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if got, want := config.Data, "kind: pipeline\nname: deploy-"+deploy+"\n"; got != want {
+			t.Errorf("Want %q got %q", want, got)
+		}
+	}
+}
+
+func TestTemplatePluginConvertCacheTemplateChanged(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\ncache:\n  ttl: 10m\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	// the template is fetched when the configuration file is
+	// rendered, and when the cached entry is checked for changes
+	// after one and two minutes.
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(4)
+
+	now := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	plugin := Template(templates, 0, 0, TemplateCache(10, time.Minute)).(*templatePlugin)
+
+	tests := []struct {
+		elapsed time.Duration
+		update  string
+		want    string
+	}{
+		{elapsed: 0, want: "kind: pipeline\nname: default\n"},
+		{elapsed: 30 * time.Second, want: "kind: pipeline\nname: default\n"},
+		{elapsed: time.Minute, want: "kind: pipeline\nname: default\n"},
+		// the change is not detected until the default ttl
+		// elapses after the template was last checked.
+		{elapsed: 90 * time.Second, update: "kind: pipeline\nname: updated\n", want: "kind: pipeline\nname: default\n"},
+		{elapsed: 2 * time.Minute, want: "kind: pipeline\nname: updated\n"},
+	}
+	for _, test := range tests {
+		if test.update != "" {
+			template.Data = test.update
+		}
+		plugin.now = func() time.Time { return now.Add(test.elapsed) }
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if got := config.Data; got != test.want {
+			t.Errorf("Want %q after %s got %q", test.want, test.elapsed, got)
+		}
+	}
+}
+
+func TestTemplatePluginConvertYamlOnly(t *testing.T) {
+	restricted := func(repo *core.Repository) bool {
+		return repo.Slug == "octocat/legacy"
+	}
+
+	tests := []struct {
+		slug string
+		name string
+		data string
+		err  string
+	}{
+		{
+			slug: "octocat/legacy",
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default'}",
+			err:  "template converter: jsonnet templates are not permitted for repository octocat/legacy, only yaml templates are allowed",
+		},
+		{
+			slug: "octocat/legacy",
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: default\n",
+		},
+		{
+			slug: "octocat/hello-world",
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default'}",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      test.slug,
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateYamlOnly(restricted))
+		_, err := plugin.Convert(noContext, req)
+		if test.err == "" && err != nil {
+			t.Errorf("Want %s allowed for %s, got %s", test.name, test.slug, err)
+		}
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want %s rejected for %s", test.name, test.slug)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertBuildHistory(t *testing.T) {
+	templateData := "kind: pipeline\nname: runs-{{ len .build.history }}\n{{ range .build.history }}# {{ .number }} {{ .status }}\n{{ end }}"
+
+	var history []*core.Build
+	for i := int64(30); i > 0; i-- {
+		history = append(history, &core.Build{Number: i, Status: core.StatusPassing})
+	}
+
+	tests := []struct {
+		history []*core.Build
+		want    string
+	}{
+		{
+			history: nil,
+			want:    "kind: pipeline\nname: runs-0\n",
+		},
+		{
+			history: history[:2],
+			want:    "kind: pipeline\nname: runs-2\n# 30 success\n# 29 success\n",
+		},
+		{
+			history: history,
+			want:    "kind: pipeline\nname: runs-25\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+			History: test.history,
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      templateData,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0)
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+			controller.Finish()
+			continue
+		}
+		if len(test.history) > maxHistory {
+			if !strings.HasPrefix(config.Data, test.want) {
+				t.Errorf("Want history limited to %d builds, got %q", maxHistory, config.Data)
+			}
+		} else if config.Data != test.want {
+			t.Errorf("Want %q got %q", test.want, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertFolderName(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: team/base\n",
+		},
+	}
+
+	base := &core.Template{
+		Name:      "team/base.yaml",
+		Data:      "kind: template\nload: common.yaml\n",
+		Namespace: "octocat",
+	}
+	common := &core.Template{
+		Name:      "common.yaml",
+		Data:      "kind: pipeline\nname: common\n",
+		Namespace: "octocat",
+	}
+	teamCommon := &core.Template{
+		Name:      "team/common.yaml",
+		Data:      "kind: pipeline\nname: team-common\n",
+		Namespace: "octocat",
+	}
+
+	// the folder-relative template is not found, and the
+	// template name is resolved from the root.
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	gomock.InOrder(
+		templates.EXPECT().FindName(gomock.Any(), "team/base.yaml", req.Repo.Namespace).Return(base, nil),
+		templates.EXPECT().FindName(gomock.Any(), "team/common.yaml", req.Repo.Namespace).Return(nil, sql.ErrNoRows),
+		templates.EXPECT().FindName(gomock.Any(), "common.yaml", req.Repo.Namespace).Return(common, nil),
+	)
+
+	plugin := Template(templates, 0, 0)
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want, got := common.Data, config.Data; want != got {
+		t.Errorf("Want %q got %q", want, got)
+	}
+
+	// the folder-relative template takes precedence.
+	templates = mock.NewMockTemplateStore(controller)
+	gomock.InOrder(
+		templates.EXPECT().FindName(gomock.Any(), "team/base.yaml", req.Repo.Namespace).Return(base, nil),
+		templates.EXPECT().FindName(gomock.Any(), "team/common.yaml", req.Repo.Namespace).Return(teamCommon, nil),
+	)
+
+	plugin = Template(templates, 0, 0)
+	config, err = plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want, got := teamCommon.Data, config.Data; want != got {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertRequireName(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nsteps:\n- name: build\n  image: golang\n",
+		Namespace: "octocat",
+	}
+
+	want := "template converter: pipeline in document 1 does not set a name"
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	// warning mode converts the configuration and reports
+	// the missing name as a warning.
+	plugin := Template(templates, 0, 0, TemplateRequireName(CheckWarn)).(InfoConverter)
+	config, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if config.Data != template.Data {
+		t.Errorf("Want %q got %q", template.Data, config.Data)
+	}
+	if len(info.Warnings) != 1 || info.Warnings[0] != want {
+		t.Errorf("Want warning %q got %q", want, info.Warnings)
+	}
+
+	// error mode fails the conversion.
+	plugin = Template(templates, 0, 0, TemplateRequireName(CheckError)).(InfoConverter)
+	_, _, err = plugin.ConvertWithInfo(noContext, req)
+	if err == nil {
+		t.Errorf("Expect error when pipeline does not set a name")
+	} else if got := err.Error(); got != want {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertParamsSchema(t *testing.T) {
+	schema := &ParamsSchema{
+		OneOf: [][]string{{"image", "build"}},
+		AnyOf: [][]string{{"commands", "script"}},
+	}
+	resolve := func(template *core.Template) *ParamsSchema {
+		if template.Name == "plugin.yaml" {
+			return schema
+		}
+		return nil
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{
+			data: "kind: template\nload: plugin.yaml\ndata:\n  image: golang\n  commands: [go test]\n",
+		},
+		{
+			data: "kind: template\nload: plugin.yaml\ndata:\n  build: .\n  script: test.sh\n",
+		},
+		{
+			data: "kind: template\nload: plugin.yaml\ndata:\n  image: golang\n  build: .\n  commands: [go test]\n",
+			err:  `template converter: exactly one of "image", "build" must be set, got 2`,
+		},
+		{
+			data: "kind: template\nload: plugin.yaml\ndata:\n  commands: [go test]\n",
+			err:  `template converter: exactly one of "image", "build" must be set, got 0`,
+		},
+		{
+			data: "kind: template\nload: plugin.yaml\ndata:\n  image: golang\n",
+			err:  `template converter: at least one of "commands", "script" must be set`,
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: test.data,
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: default\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateParamsSchema(resolve))
+		_, err := plugin.Convert(noContext, req)
+		if test.err == "" && err != nil {
+			t.Errorf("Want constraints satisfied, got %s", err)
+		}
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertTemplateParamsSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     string
+		err      string
+	}{
+		{
+			name:     "plugin.yaml",
+			template: "# one_of: image, build\n# any_of: commands, script\nkind: pipeline\nname: default\n",
+			data:     "image: golang\n  commands: [go test]\n",
+		},
+		{
+			name:     "plugin.yaml",
+			template: "# one_of: image, build\n# any_of: commands, script\nkind: pipeline\nname: default\n",
+			data:     "image: golang\n  build: .\n  commands: [go test]\n",
+			err:      `template converter: exactly one of "image", "build" must be set, got 2`,
+		},
+		{
+			name:     "plugin.yaml",
+			template: "# one_of: image, build\n# any_of: commands, script\nkind: pipeline\nname: default\n",
+			data:     "build: .\n",
+			err:      `template converter: at least one of "commands", "script" must be set`,
+		},
+		{
+			name:     "plugin.jsonnet",
+			template: "// jsonnet\n// one_of: image, build\n{kind: 'pipeline', name: 'default'}\n",
+			data:     "build: .\n",
+		},
+		{
+			name:     "plugin.jsonnet",
+			template: "// jsonnet\n// one_of: image, build\n{kind: 'pipeline', name: 'default'}\n",
+			data:     "script: test.sh\n",
+			err:      `template converter: exactly one of "image", "build" must be set, got 0`,
+		},
+		// constraints below the header are ignored.
+		{
+			name:     "plugin.yaml",
+			template: "kind: pipeline\nname: default\n# one_of: image, build\n",
+			data:     "script: test.sh\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\ndata:\n  " + test.data,
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.template,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		_, err := Template(templates, 0, 0).Convert(noContext, req)
+		if test.err == "" && err != nil {
+			t.Errorf("Want constraints satisfied, got %s", err)
+		}
+		var userErr *UserError
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			} else if !errors.As(err, &userErr) {
+				t.Errorf("Want UserError for violated constraints, got %v", err)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertEmptyConfig(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "\n  \n",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+
+	// skip mode, which is the default, does not convert
+	// the configuration file.
+	for _, plugin := range []core.ConvertService{
+		Template(templates, 0, 0),
+		Template(templates, 0, 0, TemplateEmptyConfig(EmptyConfigSkip)),
+	} {
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		}
+		if config != nil {
+			t.Errorf("Want nil config in skip mode, got %q", config.Data)
+		}
+	}
+
+	// error mode fails the conversion.
+	plugin := Template(templates, 0, 0, TemplateEmptyConfig(EmptyConfigError))
+	_, err := plugin.Convert(noContext, req)
+	if !errors.Is(err, errTemplateEmptyConfig) {
+		t.Errorf("Want empty config error, got %v", err)
+	}
+
+	// default mode converts to the default pipeline.
+	plugin = Template(templates, 0, 0, TemplateEmptyConfig(EmptyConfigDefault))
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if config == nil || config.Data != defaultEmptyConfig {
+		t.Errorf("Want default pipeline in default mode, got %v", config)
+	}
+}
+
+func TestTemplatePluginConvertTabs(t *testing.T) {
+	tests := []struct {
+		config   string
+		template string
+		err      string
+	}{
+		// tab-indented configuration file
+		{
+			config: "kind: template\nload: plugin.yaml\ndata:\n\timage: golang\n",
+			err:    "template converter: tabs are not allowed for indentation at line 4",
+		},
+		// tab-indented template output
+		{
+			config:   "kind: template\nload: plugin.yaml\n",
+			template: "kind: pipeline\nname: default\nsteps:\n- name: build\n\timage: golang\n",
+			err:      "template converter: tabs are not allowed for indentation at line 5",
+		},
+		// tabs following spaces in the indentation
+		{
+			config:   "kind: template\nload: plugin.yaml\n",
+			template: "kind: pipeline\nname: default\nsteps:\n  \t- name: build\n",
+			err:      "template converter: tabs are not allowed for indentation at line 4",
+		},
+		// tabs within values are permitted
+		{
+			config:   "kind: template\nload: plugin.yaml\n",
+			template: "kind: pipeline\nname: \"default\tpipeline\"\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: test.config,
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.template,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		if test.template != "" {
+			templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+		}
+
+		plugin := Template(templates, 0, 0, TemplateTabs(CheckError))
+		_, err := plugin.Convert(noContext, req)
+		if test.err == "" && err != nil {
+			t.Errorf("Want tabs within values permitted, got %s", err)
+		}
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertTabsWarn(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  commands:\n  - |\n    go build\n    \tgo test\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	// a tab in the indentation of a block scalar is valid yaml,
+	// and is reported as a warning when the check is in warning
+	// mode.
+	plugin := Template(templates, 0, 0, TemplateTabs(CheckWarn)).(InfoConverter)
+	_, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+	} else if want := "template converter: tabs are not allowed for indentation at line 8"; len(info.Warnings) != 1 || info.Warnings[0] != want {
+		t.Errorf("Want warning %q got %v", want, info.Warnings)
+	}
+
+	// the check is disabled by default.
+	plugin = Template(templates, 0, 0).(InfoConverter)
+	_, info, err = plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+	} else if len(info.Warnings) != 0 {
+		t.Errorf("Want no warnings, got %v", info.Warnings)
+	}
+}
+
+func TestCheckTabs(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: "kind: pipeline\nname: default\n"},
+		{data: "kind: pipeline\nname: \"default\tpipeline\"\n"},
+		{data: "kind: pipeline\n\t\nname: default\n"},
+		{data: "kind: pipeline\n\tname: default\n", want: "template converter: tabs are not allowed for indentation at line 2"},
+		{data: "steps:\n  \t- name: build\n", want: "template converter: tabs are not allowed for indentation at line 2"},
+		{data: "steps:\n- name: build\n \t image: golang\n", want: "template converter: tabs are not allowed for indentation at line 3"},
+	}
+	for _, test := range tests {
+		err := checkTabs(test.data)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("Want no error for %q got %s", test.data, err)
+		case test.want != "" && (err == nil || err.Error() != test.want):
+			t.Errorf("Want error %q got %v", test.want, err)
+		}
+	}
+}
+
+func TestTemplatePluginRenderByName(t *testing.T) {
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: {{ .repo.Slug }}\nsteps:\n- name: build\n  image: {{ .input.image }}\n",
+		Namespace: "octocat",
+	}
+
+	req := &core.ConvertArgs{
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Namespace: "octocat",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", "octocat").Return(template, nil)
+	templates.EXPECT().FindName(gomock.Any(), "missing.yaml", "octocat").Return(nil, sql.ErrNoRows)
+
+	plugin := Template(templates, 0, 0).(Renderer)
+	got, err := plugin.RenderByName(noContext, "plugin.yaml", "octocat", map[string]interface{}{"image": "golang"}, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := "kind: pipeline\nname: octocat/hello-world\nsteps:\n- name: build\n  image: golang\n"
+	if got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+
+	_, err = plugin.RenderByName(noContext, "missing.yaml", "octocat", nil, nil)
+	if !errors.Is(err, errTemplateNotFound) {
+		t.Errorf("Want template not found error, got %v", err)
+	}
+}
+
+func TestTemplatePluginConvertMaxTotalSteps(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name: "plugin.yaml",
+		Data: `---
+kind: pipeline
+name: backend
+steps:
+- name: build
+  image: golang
+- name: test
+  image: golang
+---
+kind: pipeline
+name: frontend
+steps:
+- name: build
+  image: node
+`,
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	// the total number of steps is within the limit.
+	plugin := Template(templates, 0, 0, TemplateMaxTotalSteps(3))
+	if _, err := plugin.Convert(noContext, req); err != nil {
+		t.Errorf("Want 3 steps permitted, got %s", err)
+	}
+
+	// the total number of steps exceeds the limit.
+	plugin = Template(templates, 0, 0, TemplateMaxTotalSteps(2))
+	_, err := plugin.Convert(noContext, req)
+	if err == nil {
+		t.Errorf("Want error when total steps exceed the limit")
+	} else if want, got := "template converter: configuration defines 3 steps, exceeding the maximum of 2 steps", err.Error(); want != got {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertRepoTrigger(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: default\ntrigger:\n  branch:\n  - {{ .repo.Branch }}\n  event:\n  - {{ if eq .repo.Visibility \"public\" }}pull_request{{ else }}push{{ end }}\n",
+		},
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": \"default\", \"trigger\": {\"branch\": [ctx.repo.branch], \"event\": [\"pull_request\" if ctx.repo.visibility == \"public\" else \"push\"]}}\n",
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default', trigger: {branch: [std.extVar('repo.default_branch')], event: [if std.extVar('repo.visibility') == 'public' then 'pull_request' else 'push']}}",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:       "octocat/hello-world",
+				Config:     ".drone.yml",
+				Namespace:  "octocat",
+				Branch:     "trunk",
+				Visibility: "public",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0)
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			controller.Finish()
+			continue
+		}
+
+		docs, err := parseDocuments(config.Data)
+		if err != nil || len(docs) != 1 {
+			t.Errorf("%s: want a single pipeline document, got %q", test.name, config.Data)
+			controller.Finish()
+			continue
+		}
+		trigger, _ := docs[0]["trigger"].(map[interface{}]interface{})
+		if got := fmt.Sprint(trigger["branch"]); got != "[trunk]" {
+			t.Errorf("%s: want trigger branch [trunk], got %s", test.name, got)
+		}
+		if got := fmt.Sprint(trigger["event"]); got != "[pull_request]" {
+			t.Errorf("%s: want trigger event [pull_request], got %s", test.name, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertFallback(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	fallback := "kind: pipeline\nname: notify\nsteps:\n- name: notify\n  image: plugins/slack\n"
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", req.Repo.Namespace).Return(nil, sql.ErrNoRows)
+
+	plugin := Template(templates, 0, 0, TemplateFallbackConfig(fallback)).(InfoConverter)
+	config, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Errorf("Want fallback config, got error %s", err)
+		return
+	}
+	if config.Data != fallback {
+		t.Errorf("Want fallback config %q got %q", fallback, config.Data)
+	}
+	if len(info.Warnings) != 1 || info.Warnings[0] != errTemplateNotFound.Error() {
+		t.Errorf("Want original error reported as a warning, got %q", info.Warnings)
+	}
+}
+
+func TestTemplatePluginConvertInputAtTopLevel(t *testing.T) {
+	tests := []struct {
+		config   string
+		template string
+		enabled  bool
+		want     string
+		err      string
+	}{
+		// input is accessible at the top level.
+		{
+			config:   "kind: template\nload: plugin.yaml\ndata:\n  region: eu-west-1\n",
+			template: "kind: pipeline\nname: {{ .region }}-{{ .input.region }}\n",
+			enabled:  true,
+			want:     "kind: pipeline\nname: eu-west-1-eu-west-1\n",
+		},
+		// input is not accessible at the top level by default.
+		{
+			config:   "kind: template\nload: plugin.yaml\ndata:\n  region: eu-west-1\n",
+			template: "kind: pipeline\nname: {{ .region }}-{{ .input.region }}\n",
+			want:     "kind: pipeline\nname: <no value>-eu-west-1\n",
+		},
+		// build and repo take precedence over the input.
+		{
+			config:   "kind: template\nload: plugin.yaml\ndata:\n  build: custom\n",
+			template: "kind: pipeline\nname: {{ .build.commit }}-{{ .input.build }}\n",
+			enabled:  true,
+			want:     "kind: pipeline\nname: 3d21ec53a331a6f037a91c368710b99387d012c1-custom\n",
+		},
+		// input that collides with other values is ambiguous.
+		{
+			config:   "kind: template\nload: plugin.yaml\ndata:\n  org: custom\n",
+			template: "kind: pipeline\nname: {{ .org }}\n",
+			enabled:  true,
+			err:      "template converter: input \"org\" is ambiguous at the top level, use .input.org",
+		},
+	}
+
+	org := func(namespace string) (map[string]interface{}, error) {
+		return map[string]interface{}{"team": "platform"}, nil
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: test.config,
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.template,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateInputAtTopLevel(test.enabled), TemplateOrgResolver(org))
+		config, err := plugin.Convert(noContext, req)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		} else if err != nil {
+			t.Error(err)
+		} else if config.Data != test.want {
+			t.Errorf("Want %q got %q", test.want, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertDuplicateLoads(t *testing.T) {
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: {{ .input.name }}\n",
+		Namespace: "octocat",
+	}
+
+	duplicate := "kind: template\nload: plugin.yaml\ndata:\n  name: default\n---\nkind: template\nload: plugin.yaml\ndata:\n  name: default\n"
+	different := "kind: template\nload: plugin.yaml\ndata:\n  name: backend\n---\nkind: template\nload: plugin.yaml\ndata:\n  name: frontend\n"
+
+	want := `template converter: template "plugin.yaml" is loaded more than once with identical data`
+
+	newRequest := func(data string) *core.ConvertArgs {
+		return &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: data,
+			},
+		}
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, "octocat").Return(template, nil).AnyTimes()
+
+	// identical duplicates are reported as a warning.
+	plugin := Template(templates, 0, 0, TemplateDuplicateLoads(CheckWarn)).(InfoConverter)
+	config, info, err := plugin.ConvertWithInfo(noContext, newRequest(duplicate))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want := "---\nkind: pipeline\nname: default\n---\nkind: pipeline\nname: default\n"; config.Data != want {
+		t.Errorf("Want %q got %q", want, config.Data)
+	}
+	if len(info.Warnings) != 1 || info.Warnings[0] != want {
+		t.Errorf("Want warning %q got %q", want, info.Warnings)
+	}
+
+	// identical duplicates fail the conversion.
+	plugin = Template(templates, 0, 0, TemplateDuplicateLoads(CheckError)).(InfoConverter)
+	_, _, err = plugin.ConvertWithInfo(noContext, newRequest(duplicate))
+	if err == nil {
+		t.Errorf("Want error %q", want)
+	} else if got := err.Error(); got != want {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+
+	// loading the same template with different data is
+	// permitted.
+	config, info, err = plugin.ConvertWithInfo(noContext, newRequest(different))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want := "---\nkind: pipeline\nname: backend\n---\nkind: pipeline\nname: frontend\n"; config.Data != want {
+		t.Errorf("Want %q got %q", want, config.Data)
+	}
+	if len(info.Warnings) != 0 {
+		t.Errorf("Want no warnings, got %q", info.Warnings)
+	}
+}
+
+func TestTemplatePluginConvertCron(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{
+			data: "---\nkind: pipeline\nname: nightly\n---\nkind: cron\nname: nightly\nspec:\n  schedule: \"@daily\"\n  branch: main\n",
+		},
+		{
+			data: "---\nkind: pipeline\nname: nightly\n---\nkind: schedule\nname: nightly\n",
+			err:  "template converter: document 2 has unknown kind \"schedule\"",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateKnownKinds(CheckError))
+		config, err := plugin.Convert(noContext, req)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		} else if err != nil {
+			t.Errorf("Want cron document permitted, got %s", err)
+		} else if config.Data != test.data {
+			t.Errorf("Want %q got %q", test.data, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertNameNormalizer(t *testing.T) {
+	normalize := func(name string) string {
+		return strings.ToLower(strings.Replace(name, "_", "-", -1))
+	}
+
+	template := &core.Template{
+		Name:      "my-base.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	for _, load := range []string{"my-base.yaml", "my_base.yaml", "My_Base.yaml"} {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + load + "\n",
+			},
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), "my-base.yaml", req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateNameNormalizer(normalize))
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", load, err)
+		} else if config.Data != template.Data {
+			t.Errorf("%s: want %q got %q", load, template.Data, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertEnginesUsed(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: base.yaml\n---\nkind: template\nload: plugin.star\n",
+		},
+	}
+
+	base := &core.Template{
+		Name:      "base.yaml",
+		Data:      "kind: template\nload: plugin.jsonnet\n",
+		Namespace: "octocat",
+	}
+	jsonnetTemplate := &core.Template{
+		Name:      "plugin.jsonnet",
+		Data:      "{kind: 'pipeline', name: 'backend'}",
+		Namespace: "octocat",
+	}
+	starlarkTemplate := &core.Template{
+		Name:      "plugin.star",
+		Data:      "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": \"frontend\"}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), base.Name, req.Repo.Namespace).Return(base, nil)
+	templates.EXPECT().FindName(gomock.Any(), jsonnetTemplate.Name, req.Repo.Namespace).Return(jsonnetTemplate, nil)
+	templates.EXPECT().FindName(gomock.Any(), starlarkTemplate.Name, req.Repo.Namespace).Return(starlarkTemplate, nil)
+
+	plugin := Template(templates, 0, 0).(InfoConverter)
+	_, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if want, got := "[jsonnet starlark yaml]", fmt.Sprint(info.EnginesUsed); want != got {
+		t.Errorf("Want engines used %s got %s", want, got)
+	}
+}
+
+func TestTemplatePluginConvertMaxComplexity(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  return {'kind': 'pipeline', 'name': 'default'}\n",
+		},
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  for a in range(10):\n    for b in range(10):\n      for c in range(10):\n        for d in range(10):\n          for e in range(10):\n            x = [a + b + c + d + e for f in range(10)]\n  return {'kind': 'pipeline', 'name': 'default'}\n",
+			err:  "template converter: template plugin.star has a complexity of ",
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default'}",
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default', steps: [" + strings.Repeat("{name: 'build', image: 'golang', commands: ['go build', 'go test']},", 200) + "]}",
+			err:  "template converter: template plugin.jsonnet has a complexity of ",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		_, err := Template(templates, 0, 0, TemplateMaxComplexity(500)).Convert(noContext, req)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("Want template %s permitted, got %s", test.name, err)
+		case test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)):
+			t.Errorf("Want error %q got %v", test.err, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertMaxNestingDepth(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	// the steps are nested 4 levels deep: the document,
+	// the steps list, the step and the commands list.
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  commands:\n  - go build\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	plugin := Template(templates, 0, 0, TemplateMaxNestingDepth(4))
+	if _, err := plugin.Convert(noContext, req); err != nil {
+		t.Errorf("Want nesting depth within limit, got %s", err)
+	}
+
+	plugin = Template(templates, 0, 0, TemplateMaxNestingDepth(3))
+	_, err := plugin.Convert(noContext, req)
+	if err == nil {
+		t.Errorf("Want error when nesting depth exceeds the limit")
+	} else if want, got := "template converter: document 1 has a nesting depth of 4, exceeding the maximum of 3", err.Error(); want != got {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+}
+
+func TestTemplateFuncRedact(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		keep  []int
+		want  string
+	}{
+		{value: "ghp_1234567890abcdef", want: "ghp_****"},
+		{value: "ghp_1234567890abcdef", keep: []int{2}, want: "gh****"},
+		{value: "ghp_1234567890abcdef", keep: []int{0}, want: "****"},
+		{value: "abcd", want: "****"},
+		{value: "", want: "****"},
+		{value: nil, want: "****"},
+		{value: 1234567890, want: "1234****"},
+		{value: "пароль-секрет", keep: []int{3}, want: "пар****"},
+	}
+	for _, test := range tests {
+		if got := redact(test.value, test.keep...); got != test.want {
+			t.Errorf("Want %v redacted as %q got %q", test.value, test.want, got)
+		}
+	}
+}
+
+func TestTemplatePluginConvertRequireTemplate(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: pipeline\nname: default\n",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+
+	// non-template configuration files are skipped by
+	// default.
+	config, err := Template(templates, 0, 0, TemplateRequireTemplate(false)).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+	}
+	if config != nil {
+		t.Errorf("Expect nil config returned for non-template files")
+	}
+
+	// non-template configuration files are rejected when
+	// templates are required.
+	_, err = Template(templates, 0, 0, TemplateRequireTemplate(true)).Convert(noContext, req)
+	if !errors.Is(err, errTemplateRequired) {
+		t.Errorf("Want template required error, got %v", err)
+	}
+}
+
+func TestTemplatePluginConvertFeatureFlags(t *testing.T) {
+	flags := func(repo *core.Repository) func(flag string) bool {
+		return func(flag string) bool {
+			return repo.Namespace == "octocat" && flag == "new-deploy"
+		}
+	}
+
+	templateData := "kind: pipeline\nname: {{ if flag \"new-deploy\" }}deploy-v2{{ else }}deploy{{ end }}-{{ flag \"unknown\" }}\n"
+
+	tests := []struct {
+		namespace string
+		opts      []TemplateOption
+		want      string
+	}{
+		{
+			namespace: "octocat",
+			opts:      []TemplateOption{TemplateFeatureFlags(flags)},
+			want:      "kind: pipeline\nname: deploy-v2-false\n",
+		},
+		{
+			namespace: "spaceghost",
+			opts:      []TemplateOption{TemplateFeatureFlags(flags)},
+			want:      "kind: pipeline\nname: deploy-false\n",
+		},
+		{
+			namespace: "octocat",
+			want:      "kind: pipeline\nname: deploy-false\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      test.namespace + "/hello-world",
+				Config:    ".drone.yml",
+				Namespace: test.namespace,
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      templateData,
+			Namespace: test.namespace,
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		config, err := Template(templates, 0, 0, test.opts...).Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if config.Data != test.want {
+			t.Errorf("Want %q got %q", test.want, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertCanonicalize(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "name: default\nkind: pipeline\nsteps:\n  - name: build\n    image: golang\n    commands: [go build]\n",
+		Namespace: "octocat",
+	}
+
+	tests := []struct {
+		order KeyOrder
+		want  string
+	}{
+		{
+			order: KeyOrderNone,
+			want:  template.Data,
+		},
+		{
+			order: KeyOrderSource,
+			want:  "---\nname: default\nkind: pipeline\nsteps:\n- name: build\n  image: golang\n  commands:\n  - go build\n",
+		},
+		{
+			order: KeyOrderSorted,
+			want:  "---\nkind: pipeline\nname: default\nsteps:\n- commands:\n  - go build\n  image: golang\n  name: build\n",
+		},
+	}
+
+	for _, test := range tests {
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).AnyTimes()
+
+		// the output is stable across renders.
+		plugin := Template(templates, 0, 0, TemplateCanonicalize(test.order))
+		for i := 0; i < 5; i++ {
+			config, err := plugin.Convert(noContext, req)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			if config.Data != test.want {
+				t.Errorf("Want %q got %q", test.want, config.Data)
+				break
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertEnabledEngines(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": \"default\"}\n",
+			err:  "template converter: starlark templates are disabled on this server",
+		},
+		{
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: default\n",
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default'}",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateEnabledEngines([]string{"yaml", "jsonnet"}))
+		_, err := plugin.Convert(noContext, req)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		} else if err != nil {
+			t.Errorf("Want %s enabled, got %s", test.name, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestIsTemplate(t *testing.T) {
+	pipeline := strings.Repeat("kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n", 100)
+
+	tests := []struct {
+		data string
+		want bool
+	}{
+		{data: "kind: template\nload: plugin.yaml\n", want: true},
+		{data: "kind: template", want: true},
+		{data: "kind:   template  \nload: plugin.yaml\n", want: true},
+		{data: "kind:\ttemplate\r\nload: plugin.yaml\n", want: true},
+		{data: "# comment\n\nkind: template\n", want: true},
+		{data: "load: plugin.yaml\nkind: template\n", want: true},
+		{data: "---\n" + pipeline + "---\nkind: template\nload: plugin.yaml\n", want: true},
+		{data: pipeline + "kind: template", want: true},
+		{data: "kind: templates\n", want: false},
+		{data: "kind: template-v2\n", want: false},
+		{data: "kind:template\n", want: false},
+		{data: " kind: template\n", want: false},
+		{data: "# kind: template\n", want: false},
+		{data: "name: kind: template\n", want: false},
+		{data: "kind: pipeline\nname: template\n", want: false},
+		{data: pipeline, want: false},
+		{data: "", want: false},
+	}
+	for _, test := range tests {
+		if got := isTemplate(test.data); got != test.want {
+			t.Errorf("Want isTemplate %v for %q", test.want, test.data)
+		}
+		// the result must be equivalent to the regular
+		// expression used previously.
+		if got, want := isTemplate(test.data), templateFileRE.MatchString(test.data); got != want {
+			t.Errorf("Want isTemplate %v to match regular expression for %q", want, test.data)
+		}
+	}
+}
+
+// benchmarkConfig is a large configuration file where the
+// template marker appears in the last document.
+var benchmarkConfig = strings.Repeat("---\nkind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n  commands:\n  - go build\n  - go test\n", 1000) + "---\nkind: template\nload: plugin.yaml\n"
+
+func BenchmarkIsTemplate(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		isTemplate(benchmarkConfig)
+	}
+}
+
+func BenchmarkTemplateFileRE(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		templateFileRE.MatchString(benchmarkConfig)
+	}
+}
+
+func TestTemplatePluginConvertProduces(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{
+			config: "kind: template\nload: plugin.yaml\nproduces: pipeline\n",
+		},
+		{
+			config: "kind: template\nload: plugin.yaml\nproduces: secret\n",
+			err:    `template converter: template "plugin.yaml" produces secret documents, but document 1 has kind "pipeline"`,
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: test.config,
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "---\nkind: pipeline\nname: backend\n---\nkind: pipeline\nname: frontend\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		_, err := Template(templates, 0, 0).Convert(noContext, req)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("Want error %q", test.err)
+			} else if got := err.Error(); got != test.err {
+				t.Errorf("Want error %q got %q", test.err, got)
+			}
+		} else if err != nil {
+			t.Errorf("Want produced kind to match, got %s", err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "", want: "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"1,234,567.89\"\n  DATE: \"03/04/2021\"\n"},
+		{locale: "en-US", want: "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"1,234,567.89\"\n  DATE: \"03/04/2021\"\n"},
+		{locale: "en-GB", want: "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"1,234,567.89\"\n  DATE: \"04/03/2021\"\n"},
+		{locale: "de-DE", want: "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"1.234.567,89\"\n  DATE: \"04.03.2021\"\n"},
+		{locale: "ru-RU", want: "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"1\u00a0234\u00a0567,89\"\n  DATE: \"04.03.2021\"\n"},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\ndata:\n  size: 1234567.891\n  date: \"2021-03-04T10:00:00Z\"\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"{{ formatNumber .input.size 2 }}\"\n  DATE: \"{{ formatDate .input.date }}\"\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		var opts []TemplateOption
+		if test.locale != "" {
+			opts = append(opts, TemplateLocale(test.locale))
+		}
+		config, err := Template(templates, 0, 0, opts...).Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", test.locale, err)
+		} else if config.Data != test.want {
+			t.Errorf("%s: want %q got %q", test.locale, test.want, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertLocaleUnsupported(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\ndata:\n  size: 1234567.891\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nenvironment:\n  SIZE: \"{{ formatNumber .input.size 2 }}\"\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	// a misspelled locale is reported rather than silently
+	// formatting using the default locale.
+	_, err := Template(templates, 0, 0, TemplateLocale("de_DE")).Convert(noContext, req)
+	if err == nil {
+		t.Fatalf("Want error for an unsupported locale")
+	}
+	if got, want := err.Error(), `template converter: unsupported locale "de_DE"`; got != want {
+		t.Errorf("Want error %q got %q", want, got)
+	}
+	var userErr *UserError
+	if !errors.As(err, &userErr) {
+		t.Errorf("Want user error, got %T", err)
+	}
+}
+
+func TestTemplateFuncFormatDate(t *testing.T) {
+	l := locales["de-DE"]
+	date := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+	for _, v := range []interface{}{date, date.Unix(), int(date.Unix()), "2021-03-04T10:00:00Z"} {
+		got, err := formatDate(l, v)
+		if err != nil {
+			t.Error(err)
+		} else if want := "04.03.2021"; got != want {
+			t.Errorf("Want %T formatted as %q got %q", v, want, got)
+		}
+	}
+	if _, err := formatDate(l, true); err == nil {
+		t.Errorf("Want error formatting an unsupported type")
+	}
+}
+
+func TestTemplatePluginConvertCollapseBlankLines(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\n\n\n\nname: default\n  \n\nsteps:\n- name: build\n  commands: |\n    echo a\n\n\n    echo b\n- name: test\n  image: golang\n\n\n",
+		Namespace: "octocat",
+	}
+
+	// blank lines within the block scalar are retained.
+	want := "kind: pipeline\n\nname: default\n\nsteps:\n- name: build\n  commands: |\n    echo a\n\n\n    echo b\n- name: test\n  image: golang\n"
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	config, err := Template(templates, 0, 0, TemplateCollapseBlankLines(true)).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if config.Data != want {
+		t.Errorf("Want %q got %q", want, config.Data)
+	}
+}
+
+func TestTemplatePluginConvertPinnedImages(t *testing.T) {
+	digest := "sha256:6d0d8f2e7b7ad1c5f1a6f2a4e1cfd3c1f1c5b9a7d3e9f0a1b2c3d4e5f6a7b8c9"
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{
+			data: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang@" + digest + "\nservices:\n- name: cache\n  image: redis:6@" + digest + "\n",
+		},
+		{
+			data: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang:1.16\n",
+			err:  `template converter: pipeline "default" step "build" image "golang:1.16" is not pinned to a digest`,
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+		// warning mode reports tag-only images as warnings.
+		plugin := Template(templates, 0, 0, TemplatePinnedImages(CheckWarn)).(InfoConverter)
+		_, info, err := plugin.ConvertWithInfo(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if test.err == "" && len(info.Warnings) != 0 {
+			t.Errorf("Want no warnings for pinned images, got %q", info.Warnings)
+		} else if test.err != "" && (len(info.Warnings) != 1 || info.Warnings[0] != test.err) {
+			t.Errorf("Want warning %q got %q", test.err, info.Warnings)
+		}
+
+		// error mode rejects tag-only images.
+		plugin = Template(templates, 0, 0, TemplatePinnedImages(CheckError)).(InfoConverter)
+		_, _, err = plugin.ConvertWithInfo(noContext, req)
+		if test.err == "" && err != nil {
+			t.Errorf("Want pinned images permitted, got %s", err)
+		} else if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Want error %q got %v", test.err, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertLimitOverrides(t *testing.T) {
+	template := &core.Template{
+		Name:      "plugin.star",
+		Data:      "def main(ctx):\n  steps = []\n  for i in range(1000):\n    steps.append({\"name\": \"step%d\" % i})\n  return {\"kind\": \"pipeline\", \"name\": \"default\", \"steps\": steps[:1]}\n",
+		Namespace: "octocat",
+	}
+
+	overrides := WithLimits(noContext, Limits{StepLimit: 1000000})
+
+	tests := []struct {
+		ctx     context.Context
+		trigger string
+		fail    bool
+	}{
+		// the plugin step limit is exceeded.
+		{ctx: noContext, trigger: "octocat", fail: true},
+		// the step limit is raised by a trusted caller.
+		{ctx: overrides, trigger: "octocat", fail: false},
+		// overrides are ignored for webhook builds.
+		{ctx: overrides, trigger: core.TriggerHook, fail: true},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After:   "3d21ec53a331a6f037a91c368710b99387d012c1",
+				Trigger: test.trigger,
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.star\n",
+			},
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		_, err := Template(templates, 100, 0).Convert(test.ctx, req)
+		if test.fail && err == nil {
+			t.Errorf("Want step limit exceeded for trigger %s", test.trigger)
+		}
+		if !test.fail && err != nil {
+			t.Errorf("Want step limit override for trigger %s, got %s", test.trigger, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertLimitOverridesCache(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After:   "3d21ec53a331a6f037a91c368710b99387d012c1",
+			Trigger: "octocat",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.star\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.star",
+		Data:      "def main(ctx):\n  steps = []\n  for i in range(1000):\n    steps.append({\"name\": \"step%d\" % i})\n  return {\"kind\": \"pipeline\", \"name\": \"default\", \"steps\": steps[:1]}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).AnyTimes()
+
+	plugin := Template(templates, 100, 0, TemplateCache(10, time.Minute))
+	if _, err := plugin.Convert(WithLimits(noContext, Limits{StepLimit: 1000000}), req); err != nil {
+		t.Errorf("Want step limit override, got %s", err)
+	}
+
+	// the configuration converted using the raised limits
+	// is not returned to conversions held to the default
+	// limits.
+	if _, err := plugin.Convert(noContext, req); err == nil {
+		t.Errorf("Want step limit exceeded without the override")
+	}
+}
+
+func TestTemplatePluginConvertBuildTrigger(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		trigger string
+		want    string
+	}{
+		{
+			name:    "plugin.yaml",
+			data:    "kind: pipeline\nname: {{ if eq .build.trigger \"@cron\" }}scheduled{{ else if eq .build.trigger \"@hook\" }}webhook{{ else if .build.trigger }}manual{{ else }}unknown{{ end }}\n",
+			trigger: core.TriggerCron,
+			want:    "scheduled",
+		},
+		{
+			name:    "plugin.yaml",
+			data:    "kind: pipeline\nname: {{ if eq .build.trigger \"@cron\" }}scheduled{{ else if eq .build.trigger \"@hook\" }}webhook{{ else if .build.trigger }}manual{{ else }}unknown{{ end }}\n",
+			trigger: "octocat",
+			want:    "manual",
+		},
+		{
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: {{ if eq .build.trigger \"@cron\" }}scheduled{{ else if eq .build.trigger \"@hook\" }}webhook{{ else if .build.trigger }}manual{{ else }}unknown{{ end }}\n",
+			want: "unknown",
+		},
+		{
+			name:    "plugin.star",
+			data:    "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": \"webhook\" if ctx.build.trigger == \"@hook\" else \"other\"}\n",
+			trigger: core.TriggerHook,
+			want:    "webhook",
+		},
+		{
+			name:    "plugin.jsonnet",
+			data:    "{kind: 'pipeline', name: if std.extVar('build.trigger') == '@cron' then 'scheduled' else 'other'}",
+			trigger: core.TriggerCron,
+			want:    "scheduled",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After:   "3d21ec53a331a6f037a91c368710b99387d012c1",
+				Trigger: test.trigger,
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		config, err := Template(templates, 0, 0).Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			controller.Finish()
+			continue
+		}
+		docs, err := parseDocuments(config.Data)
+		if err != nil || len(docs) != 1 {
+			t.Errorf("%s: want a single document, got %q", test.name, config.Data)
+		} else if got := docs[0]["name"]; got != test.want {
+			t.Errorf("%s: want name %q got %v", test.name, test.want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertUnknownFields(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nstep:\n- name: build\n  image: golang\ntriggers:\n  branch: [main]\n",
+		Namespace: "octocat",
+	}
+
+	want := `template converter: pipeline "default" has unknown fields: step, triggers`
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(3)
+
+	// unknown fields are not checked by default.
+	if _, err := Template(templates, 0, 0).Convert(noContext, req); err != nil {
+		t.Error(err)
+	}
+
+	plugin := Template(templates, 0, 0, TemplateUnknownFields(CheckWarn)).(InfoConverter)
+	_, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+	} else if len(info.Warnings) != 1 || info.Warnings[0] != want {
+		t.Errorf("Want warning %q got %q", want, info.Warnings)
+	}
+
+	_, err = Template(templates, 0, 0, TemplateUnknownFields(CheckError)).Convert(noContext, req)
+	if err == nil || err.Error() != want {
+		t.Errorf("Want error %q got %v", want, err)
+	}
+}
+
+func TestTemplateWithConfig(t *testing.T) {
+	config := TemplateConfig{
+		StepLimit:            1,
+		SizeLimit:            2,
+		MaxInclusionDepth:    4,
+		MaxTotalSteps:        5,
+		MaxNestingDepth:      6,
+		ExtensionSearchOrder: []string{".star"},
+		EnabledEngines:       []string{"yaml"},
+		ReservedKeys:         []string{"privileged"},
+		RegistryMirror:       "mirror.company.com/",
+		RegistryMirrorTable:  map[string]string{"docker.io": "mirror.company.com"},
+		CacheSize:            10,
+		CacheTTL:             time.Minute,
+		RequireName:          CheckWarn,
+		DuplicateLoads:       CheckError,
+		KnownKinds:           CheckWarn,
+		PinnedImages:         CheckError,
+		UnknownFields:        CheckWarn,
+		Canonicalize:         KeyOrderSorted,
+		EmptyConfig:          EmptyConfigError,
+		FallbackConfig:       "kind: pipeline\n",
+		Locale:               "de-DE",
+		InputAtTopLevel:      true,
+		RequireTemplate:      true,
+		CollapseBlankLines:   true,
+		AllowRunners:         func(*core.Repository) []string { return nil },
+		Funcs:                func(string) templating.FuncMap { return nil },
+		OrgResolver:          func(string) (map[string]interface{}, error) { return nil, nil },
+		YamlOnly:             func(*core.Repository) bool { return false },
+		ParamsSchema:         func(*core.Template) *ParamsSchema { return nil },
+		NameNormalizer:       strings.ToLower,
+		FeatureFlags:         func(*core.Repository) func(string) bool { return nil },
+	}
+
+	p := TemplateWithConfig(nil, config).(*templatePlugin)
+	if got, want := p.stepLimit, config.StepLimit; got != want {
+		t.Errorf("Want step limit %d got %d", want, got)
+	}
+	if got, want := p.sizeLimit, config.SizeLimit; got != want {
+		t.Errorf("Want size limit %d got %d", want, got)
+	}
+	if got, want := p.maxDepth, config.MaxInclusionDepth; got != want {
+		t.Errorf("Want max inclusion depth %d got %d", want, got)
+	}
+	if got, want := p.maxTotalSteps, config.MaxTotalSteps; got != want {
+		t.Errorf("Want max total steps %d got %d", want, got)
+	}
+	if got, want := p.maxNesting, config.MaxNestingDepth; got != want {
+		t.Errorf("Want max nesting depth %d got %d", want, got)
+	}
+	if got := p.searchOrder; len(got) != 1 || got[0] != ".star" {
+		t.Errorf("Want search order %v got %v", config.ExtensionSearchOrder, got)
+	}
+	if got := p.engines; len(got) != 1 || got[0] != "yaml" {
+		t.Errorf("Want engines %v got %v", config.EnabledEngines, got)
+	}
+	if got := p.reservedKeys; len(got) != 1 || got[0] != "privileged" {
+		t.Errorf("Want reserved keys %v got %v", config.ReservedKeys, got)
+	}
+	if got, want := p.mirror, "mirror.company.com"; got != want {
+		t.Errorf("Want registry mirror %q got %q", want, got)
+	}
+	if got := p.mirrorTable["docker.io"]; got != "mirror.company.com" {
+		t.Errorf("Want registry mirror table %v got %v", config.RegistryMirrorTable, p.mirrorTable)
+	}
+	if p.cache == nil {
+		t.Errorf("Want cache enabled")
+	}
+	if got, want := p.cacheTTL, config.CacheTTL; got != want {
+		t.Errorf("Want cache ttl %s got %s", want, got)
+	}
+	if got, want := p.requireName, config.RequireName; got != want {
+		t.Errorf("Want require name mode %v got %v", want, got)
+	}
+	if got, want := p.duplicateLoads, config.DuplicateLoads; got != want {
+		t.Errorf("Want duplicate loads mode %v got %v", want, got)
+	}
+	if got, want := p.knownKinds, config.KnownKinds; got != want {
+		t.Errorf("Want known kinds mode %v got %v", want, got)
+	}
+	if got, want := p.pinnedImages, config.PinnedImages; got != want {
+		t.Errorf("Want pinned images mode %v got %v", want, got)
+	}
+	if got, want := p.unknownFields, config.UnknownFields; got != want {
+		t.Errorf("Want unknown fields mode %v got %v", want, got)
+	}
+	if got, want := p.keyOrder, config.Canonicalize; got != want {
+		t.Errorf("Want key order %v got %v", want, got)
+	}
+	if got, want := p.emptyConfig, config.EmptyConfig; got != want {
+		t.Errorf("Want empty config mode %v got %v", want, got)
+	}
+	if got, want := p.fallback, config.FallbackConfig; got != want {
+		t.Errorf("Want fallback config %q got %q", want, got)
+	}
+	if got, want := p.locale, config.Locale; got != want {
+		t.Errorf("Want locale %q got %q", want, got)
+	}
+	if !p.inputAtTopLevel {
+		t.Errorf("Want input at top level")
+	}
+	if !p.requireTemplate {
+		t.Errorf("Want require template")
+	}
+	if !p.collapseBlankLines {
+		t.Errorf("Want collapse blank lines")
+	}
+	if p.allowRunners == nil || p.funcs == nil || p.org == nil || p.yamlOnly == nil ||
+		p.schema == nil || p.normalize == nil || p.flags == nil {
+		t.Errorf("Want resolver functions set")
+	}
+}
+
+func TestTemplateWithConfigConvert(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nstep:\n- name: build\n  image: golang\n",
+		Namespace: "octocat",
+	}
+
+	want := `template converter: pipeline "default" has unknown fields: step`
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	config := TemplateConfig{UnknownFields: CheckError}
+	_, err := TemplateWithConfig(templates, config).Convert(noContext, req)
+	if err == nil || err.Error() != want {
+		t.Errorf("Want error %q got %v", want, err)
+	}
+
+	// options are applied after the configuration.
+	_, err = TemplateWithConfig(templates, config, TemplateUnknownFields(CheckOff)).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTemplatePluginConvertLoadRewriter(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	canary := &core.Template{
+		Name:      "plugin-canary.yaml",
+		Data:      "kind: pipeline\nname: canary\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), canary.Name, req.Repo.Namespace).Return(canary, nil)
+
+	rewrite := func(ctx context.Context, repo *core.Repository, load string) (string, error) {
+		if repo.Slug == "octocat/hello-world" && load == "plugin.yaml" {
+			return "plugin-canary.yaml", nil
+		}
+		return load, nil
+	}
+
+	config, err := Template(templates, 0, 0, TemplateLoadRewriter(rewrite)).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got, want := config.Data, canary.Data; got != want {
+		t.Errorf("Want rewritten template %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertLoadRewriterError(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	rewrite := func(ctx context.Context, repo *core.Repository, load string) (string, error) {
+		return "", errors.New("routing table unavailable")
+	}
+
+	_, err := Template(nil, 0, 0, TemplateLoadRewriter(rewrite)).Convert(noContext, req)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Errorf("Want ServerError for rewrite failure, got %v", err)
+	}
+}
+
+func TestTemplatePluginConvertRequiredSecrets(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\nrequires_secrets: [docker_password, docker_username, docker_password]\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	plugin := Template(templates, 0, 0).(InfoConverter)
+	_, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	want := []string{"docker_password", "docker_username"}
+	if got := info.RequiredSecrets; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Want required secrets %v got %v", want, got)
+	}
+}
+
+func TestTemplatePluginConvertRequiredSecretsExist(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\nrequires_secrets: [docker_password, docker_username]\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	exists := func(ctx context.Context, repo *core.Repository, name string) (bool, error) {
+		return name == "docker_username", nil
+	}
+
+	want := `template converter: required secret "docker_password" does not exist`
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	plugin := Template(templates, 0, 0, TemplateRequiredSecrets(CheckWarn, exists)).(InfoConverter)
+	_, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+	} else if len(info.Warnings) != 1 || info.Warnings[0] != want {
+		t.Errorf("Want warning %q got %q", want, info.Warnings)
+	}
+
+	_, err = Template(templates, 0, 0, TemplateRequiredSecrets(CheckError, exists)).Convert(noContext, req)
+	if err == nil || err.Error() != want {
+		t.Errorf("Want error %q got %v", want, err)
+	}
+}
+
+func TestTemplatePluginConvertEngineHint(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "// jsonnet\n{kind: 'pipeline', name: 'jsonnet'}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	// the hint is ignored by default, and the template
+	// is rendered as yaml.
+	config, err := Template(templates, 0, 0).Convert(noContext, req)
+	if err == nil && strings.Contains(config.Data, `"name": "jsonnet"`) {
+		t.Errorf("Want engine hint ignored by default")
+	}
+
+	plugin := Template(templates, 0, 0, TemplateEngineHints(true)).(InfoConverter)
+	config, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(config.Data, `"name": "jsonnet"`) {
+		t.Errorf("Want template rendered as jsonnet, got %q", config.Data)
+	}
+	if got := info.EnginesUsed; len(got) != 1 || got[0] != "jsonnet" {
+		t.Errorf("Want engines used [jsonnet] got %v", got)
+	}
+}
+
+func TestEngineHint(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: "// jsonnet\n{}", want: "jsonnet"},
+		{data: "# starlark\ndef main(ctx):", want: "starlark"},
+		{data: "  #yaml  \nkind: pipeline", want: "yaml"},
+		{data: "// jsonnet", want: "jsonnet"},
+		{data: "# build pipeline\nkind: pipeline", want: ""},
+		{data: "kind: pipeline\n// jsonnet", want: ""},
+		{data: "", want: ""},
+	}
+	for _, test := range tests {
+		if got := engineHint(test.data); got != test.want {
+			t.Errorf("Want engine hint %q for %q, got %q", test.want, test.data, got)
+		}
+	}
+}
+
+func TestTemplatePluginConvertLinter(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- image: golang\n",
+		Namespace: "octocat",
+	}
+
+	// lint rule requiring each step to be named.
+	lint := func(ctx context.Context, repo *core.Repository, data string) []*LintError {
+		if strings.Contains(data, "- image:") {
+			return []*LintError{{Document: 1, Name: "default", Message: "linter: invalid or missing name"}}
+		}
+		return nil
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	_, err := Template(templates, 0, 0, TemplateLinter(lint)).Convert(noContext, req)
+	var lintErrs LintErrors
+	if !errors.As(err, &lintErrs) {
+		t.Errorf("Want lint errors, got %v", err)
+		return
+	}
+	if len(lintErrs) != 1 || lintErrs[0].Name != "default" {
+		t.Errorf("Want lint error in pipeline default, got %v", lintErrs)
+	}
+	var userErr *UserError
+	if !errors.As(err, &userErr) {
+		t.Errorf("Want lint errors classified as UserError")
+	}
+}
+
+func TestTemplatePluginConvertNegativeCache(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", req.Repo.Namespace).Return(nil, sql.ErrNoRows)
+
+	plugin := Template(templates, 0, 0, TemplateNegativeCache(10, time.Minute))
+	for i := 0; i < 3; i++ {
+		if _, err := plugin.Convert(noContext, req); !errors.Is(err, errTemplateNotFound) {
+			t.Errorf("Want template not found error got %v", err)
+		}
+	}
+}
+
+func TestTemplatePluginConvertCacheBackend(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	// the template is rendered once, after which the converted
+	// configuration file is returned from the cache without
+	// fetching the template.
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(1)
+
+	cache := newFakeCache()
+	plugin := Template(templates, 0, 0, TemplateCacheBackend(cache), TemplateCache(10, time.Minute))
+	for i := 0; i < 3; i++ {
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if got, want := config.Data, template.Data; got != want {
+			t.Errorf("Want %q got %q", want, got)
+		}
+	}
+
+	if len(cache.values) != 1 {
+		t.Errorf("Want converted configuration stored in the cache backend")
+	}
+	for _, ttl := range cache.ttls {
+		if want := time.Minute; ttl != want {
+			t.Errorf("Want cache ttl %s got %s", want, ttl)
+		}
+	}
+}
+
+func TestTemplatePluginConvertCacheBackendNegative(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), "plugin.yaml", req.Repo.Namespace).Return(nil, sql.ErrNoRows)
+
+	cache := newFakeCache()
+	plugin := Template(templates, 0, 0, TemplateNegativeCache(10, time.Minute), TemplateCacheBackend(cache))
+	for i := 0; i < 3; i++ {
+		if _, err := plugin.Convert(noContext, req); !errors.Is(err, errTemplateNotFound) {
+			t.Errorf("Want template not found error got %v", err)
+		}
+	}
+
+	key := fmt.Sprintf(negativeKeyf, "octocat", "plugin.yaml")
+	if got, want := cache.ttls[key], time.Minute; got != want {
+		t.Errorf("Want template not found stored in the cache backend with ttl %s got %s", want, got)
+	}
+}
+
+func TestTemplatePluginConvertPrettyPrint(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.star\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.star",
+		Data:      "def main(ctx):\n  return [{\"kind\": \"pipeline\", \"name\": \"default\", \"steps\": [{\"name\": \"build\", \"image\": \"golang\"}]}, {\"kind\": \"secret\", \"name\": \"token\"}]\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	compact, err := Template(templates, 0, 0).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pretty, err := Template(templates, 0, 0, TemplatePrettyPrint(true)).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(pretty.Data, "\n  \"steps\": [\n    {\n      \"name\": \"build\",") {
+		t.Errorf("Want output indented using two spaces, got %q", pretty.Data)
+	}
+
+	// the pretty-printed output must have the same documents
+	// as the compact output.
+	a, b := splitDocuments(compact.Data), splitDocuments(pretty.Data)
+	if len(a) != 2 || len(b) != len(a) {
+		t.Errorf("Want 2 documents got %d and %d", len(a), len(b))
+		return
+	}
+	for i := range a {
+		var want, got interface{}
+		if err := json.Unmarshal([]byte(a[i]), &want); err != nil {
+			t.Error(err)
+		}
+		if err := json.Unmarshal([]byte(b[i]), &got); err != nil {
+			t.Error(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Want document %d %v got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestPrettyPrint(t *testing.T) {
+	data := "---\n{\"kind\": \"pipeline\", \"name\": \"default\"}\n---\nkind: secret\n"
+	want := "---\n{\n  \"kind\": \"pipeline\",\n  \"name\": \"default\"\n}\n---\nkind: secret\n"
+	if got := prettyPrint(data); got != want {
+		t.Errorf("Want pretty-printed %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertEngineMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "plugin.yaml",
+			data: "local name = 'default';\n{\n  kind: 'pipeline',\n  name: name,\n}\n",
+			want: `template converter: template "plugin.yaml" is rendered as yaml, but appears to contain jsonnet syntax; use the .jsonnet file extension`,
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "def main(ctx):\n  return {'kind': 'pipeline', 'name': 'default'}\n",
+			want: `template converter: template "plugin.jsonnet" is rendered as jsonnet, but appears to contain starlark syntax; use the .star file extension`,
+		},
+		{
+			// shell statements in yaml block scalars are not
+			// mistaken for jsonnet.
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: alpine\n  commands:\n  - |\n    local name = test\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		_, err := Template(templates, 0, 0).Convert(noContext, req)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("Want no error for %s got %v", test.name, err)
+		case test.want != "" && (err == nil || !strings.HasPrefix(err.Error(), test.want)):
+			t.Errorf("Want error %q got %v", test.want, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertProvenance(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+		Updated:   1600000000,
+	}
+
+	checksum := sha256.Sum256([]byte(template.Data))
+	want := "kind: pipeline\nname: default\n# kind: provenance\n# templates:\n" +
+		"# - name: plugin.yaml\n#   namespace: octocat\n#   version: 1600000000\n" +
+		"#   checksum: " + hex.EncodeToString(checksum[:]) + "\n#   engine: yaml\n#   rendered: \"2020-09-13T12:26:40Z\"\n"
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	plugin := Template(templates, 0, 0, TemplateEmitProvenance(true)).(*templatePlugin)
+	plugin.now = func() time.Time { return time.Unix(1600000000, 0) }
+
+	config, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if got := config.Data; got != want {
+		t.Errorf("Want provenance comment\n%s\ngot\n%s", want, got)
+	}
+	if kinds, err := DocumentKinds(config.Data); err != nil || len(kinds) != 1 {
+		t.Errorf("Want provenance comment to not add a document, got kinds %q", kinds)
+	}
+	if len(info.Templates) != 1 || info.Templates[0].Name != "plugin.yaml" || info.Templates[0].Engine != "yaml" {
+		t.Errorf("Want template usage reported in conversion info, got %v", info.Templates)
+	}
+}
+
+func TestTemplatePluginConvertDefaultBranch(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		branch string
+		want   string
+	}{
+		{
+			name:   "plugin.yaml",
+			data:   "kind: pipeline\nname: default\nclone:\n  branch: \"{{ .repo.default_branch }}\"\ntrigger:\n  branch: [\"{{ .repo.branch }}\"]\n",
+			branch: "trunk",
+			want:   "kind: pipeline\nname: default\nclone:\n  branch: \"trunk\"\ntrigger:\n  branch: [\"trunk\"]\n",
+		},
+		{
+			// the default branch is empty when unknown.
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: default\nclone:\n  branch: \"{{ .repo.default_branch }}\"\ntrigger:\n  branch: [\"{{ .repo.branch }}\"]\n",
+			want: "kind: pipeline\nname: default\nclone:\n  branch: \"\"\ntrigger:\n  branch: [\"\"]\n",
+		},
+		{
+			name:   "plugin.star",
+			data:   "def main(ctx):\n  return {\"kind\": \"pipeline\", \"name\": ctx.repo.default_branch + \"-\" + ctx.repo.branch}\n",
+			branch: "trunk",
+			want:   "\"name\": \"trunk-trunk\"",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+				Branch:    test.branch,
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		config, err := Template(templates, 0, 0).Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if got := config.Data; !strings.Contains(got, test.want) {
+			t.Errorf("%s: want %q in %q", test.name, test.want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertStoreRetry(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	gomock.InOrder(
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(nil, context.DeadlineExceeded),
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil),
+	)
+
+	plugin := Template(templates, 0, 0, TemplateWithStoreRetry(3, time.Millisecond))
+	config, err := plugin.Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+	} else if got, want := config.Data, template.Data; got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+}
+
+func TestTemplatePluginConvertDiagnostics(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.star\ndata:\n  image: golang\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.star",
+		Data:      "def main(ctx):\n  print('image is %s' % ctx.input.image)\n  return {'kind': 'pipeline', 'name': 'default'}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	plugin := Template(templates, 0, 0).(InfoConverter)
+	config, info, err := plugin.ConvertWithInfo(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Contains(config.Data, "image is") {
+		t.Errorf("Want print output excluded from the configuration")
+	}
+	want := "plugin.star: image is golang"
+	if len(info.Diagnostics) != 1 || info.Diagnostics[0] != want {
+		t.Errorf("Want diagnostics [%q] got %q", want, info.Diagnostics)
+	}
+}
+
+func TestTemplatePluginConvertStarlarkTrace(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.star\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.star",
+		Data:      "def main(ctx):\n  return {'kind': 'pipeline', 'name': str(len([1, 2]))}\n",
+		Namespace: "octocat",
+	}
+
+	tests := []struct {
+		limit int
+		want  []string
+	}{
+		{limit: 0},
+		{limit: 2, want: []string{": main", ": len at plugin.star:2:"}},
+		{limit: 10, want: []string{": main", ": len at plugin.star:2:", ": str at plugin.star:2:", ": main returned"}},
+	}
+
+	for _, test := range tests {
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateStarlarkTrace(test.limit)).(InfoConverter)
+		_, info, err := plugin.ConvertWithInfo(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if len(info.Trace) != len(test.want) {
+			t.Errorf("Want %d trace entries got %q", len(test.want), info.Trace)
+		} else {
+			for i, want := range test.want {
+				if got := info.Trace[i]; !strings.HasPrefix(got, "plugin.star: step ") || !strings.Contains(got, want) {
+					t.Errorf("Want trace entry containing %q got %q", want, got)
+				}
+			}
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertNamePattern(t *testing.T) {
+	pattern := regexp.MustCompile(`^platform-[a-z0-9-]+\.(yaml|star|jsonnet)$`)
+
+	tests := []struct {
+		load string
+		want string
+	}{
+		{load: "platform-go.yaml"},
+		{load: "plugin.yaml", want: `template converter: template name "plugin.yaml" does not match the required pattern "^platform-[a-z0-9-]+\\.(yaml|star|jsonnet)$"`},
+		{load: "platform_go.yaml", want: `template converter: template name "platform_go.yaml" does not match the required pattern "^platform-[a-z0-9-]+\\.(yaml|star|jsonnet)$"`},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.load + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.load,
+			Data:      "kind: pipeline\nname: default\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		// templates that do not match the pattern are
+		// rejected before they are resolved.
+		templates := mock.NewMockTemplateStore(controller)
+		if test.want == "" {
+			templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+		}
+
+		_, err := Template(templates, 0, 0, TemplateNamePattern(pattern)).Convert(noContext, req)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("Want template %q permitted, got %s", test.load, err)
+		case test.want != "" && (err == nil || err.Error() != test.want):
+			t.Errorf("Want error %q got %v", test.want, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertClockAndSeed(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n  environment:\n    DATE: \"{{ (now).Format \"2006-01-02\" }}\"\n    SHARD: \"{{ randInt 0 1000 }}\"\n    TOKEN: \"{{ randAlphaNum 12 }}\"\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+	clock := func() time.Time { return time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC) }
+	seed := func() int64 { return 42 }
+
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		plugin := Template(templates, 0, 0, TemplateClock(clock), TemplateRandSeed(seed))
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		outputs = append(outputs, config.Data)
+	}
+	if outputs[0] != outputs[1] {
+		t.Errorf("Want stable output with injected sources, got %q and %q", outputs[0], outputs[1])
+	}
+	if !strings.Contains(outputs[0], `DATE: "2020-09-13"`) {
+		t.Errorf("Want date from injected clock, got %q", outputs[0])
+	}
+}
+
+func TestTemplatePluginConvertForbiddenCommands(t *testing.T) {
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`(curl|wget)[^|]*\|\s*(ba)?sh`),
+	}
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{command: "go build ./..."},
+		{command: "curl -sSL https://example.com/install.sh | sh", want: `template converter: pipeline "default" step "build" command "curl -sSL https://example.com/install.sh | sh" matches forbidden pattern "(curl|wget)[^|]*\\|\\s*(ba)?sh"`},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\ndata:\n  command: " + test.command + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n  commands:\n  - {{ .input.command }}\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+		plugin := Template(templates, 0, 0, TemplateForbiddenCommands(CheckWarn, patterns)).(InfoConverter)
+		_, info, err := plugin.ConvertWithInfo(noContext, req)
+		switch {
+		case err != nil:
+			t.Error(err)
+		case test.want == "" && len(info.Warnings) != 0:
+			t.Errorf("Want no warnings for %q got %q", test.command, info.Warnings)
+		case test.want != "" && (len(info.Warnings) != 1 || info.Warnings[0] != test.want):
+			t.Errorf("Want warning %q got %q", test.want, info.Warnings)
+		}
+
+		_, err = Template(templates, 0, 0, TemplateForbiddenCommands(CheckError, patterns)).Convert(noContext, req)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("Want command %q permitted, got %s", test.command, err)
+		case test.want != "" && (err == nil || err.Error() != test.want):
+			t.Errorf("Want error %q got %v", test.want, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertDedupDocuments(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{
+			config: "kind: template\nload: plugin.yaml\ndata:\n  name: test\n---\nkind: template\nload: plugin.yaml\ndata:\n  name: test\n",
+			want:   "---\nkind: pipeline\nname: test\n",
+		},
+		{
+			config: "kind: template\nload: plugin.yaml\ndata:\n  name: test\n---\nkind: template\nload: plugin.yaml\ndata:\n  name: deploy\n",
+			want:   "kind: pipeline\nname: test\n---\nkind: pipeline\nname: deploy\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: test.config,
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: {{ .input.name }}\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).AnyTimes()
+
+		config, err := Template(templates, 0, 0, TemplateDedupDocuments(true)).Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if got := config.Data; strings.TrimPrefix(got, "---\n") != strings.TrimPrefix(test.want, "---\n") {
+			t.Errorf("Want configuration %q got %q", test.want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestDedupDocuments(t *testing.T) {
+	data := "kind: pipeline\nname: test\n---\nkind: pipeline\nname: test\n---\nkind: pipeline\nname: deploy\n---\nkind: pipeline\nname: test\n"
+	want := "---\nkind: pipeline\nname: test\n---\nkind: pipeline\nname: deploy\n"
+	got, removed := dedupDocuments(data)
+	if got != want {
+		t.Errorf("Want %q got %q", want, got)
+	}
+	if removed != 2 {
+		t.Errorf("Want 2 documents removed got %d", removed)
+	}
+
+	// the configuration is unchanged without duplicates.
+	data = "kind: pipeline\nname: test\n---\nkind: pipeline\nname: deploy\n"
+	if got, removed := dedupDocuments(data); got != data || removed != 0 {
+		t.Errorf("Want configuration unchanged, got %q", got)
+	}
+}
+
+func TestTemplatePluginConvertDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n"},
+		{data: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n  image: node\n", want: `template converter: document 1 has duplicate key "steps[0].image"`},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(2)
+
+		plugin := Template(templates, 0, 0, TemplateDuplicateKeys(CheckWarn)).(InfoConverter)
+		_, info, err := plugin.ConvertWithInfo(noContext, req)
+		switch {
+		case err != nil:
+			t.Error(err)
+		case test.want == "" && len(info.Warnings) != 0:
+			t.Errorf("Want no warnings got %q", info.Warnings)
+		case test.want != "" && (len(info.Warnings) != 1 || info.Warnings[0] != test.want):
+			t.Errorf("Want warning %q got %q", test.want, info.Warnings)
+		}
+
+		_, err = Template(templates, 0, 0, TemplateDuplicateKeys(CheckError)).Convert(noContext, req)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("Want configuration permitted, got %s", err)
+		case test.want != "" && (err == nil || err.Error() != test.want):
+			t.Errorf("Want error %q got %v", test.want, err)
+		}
+		controller.Finish()
+	}
+}
+
+func TestCheckDuplicateKeys(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: "kind: pipeline\nname: test\n---\nkind: pipeline\nname: deploy\n"},
+		{data: "kind: pipeline\nname: test\n---\nkind: pipeline\nname: deploy\nname: test\n", want: `template converter: document 2 has duplicate key "name"`},
+		{data: "kind: pipeline\nenvironment:\n  GOOS: linux\n  GOOS: darwin\n", want: `template converter: document 1 has duplicate key "environment.GOOS"`},
+		// keys merged from an anchor may be overridden.
+		{data: "kind: pipeline\ndefaults: &defaults\n  image: golang\nsteps:\n- <<: *defaults\n  image: node\n"},
+	}
+	for _, test := range tests {
+		err := checkDuplicateKeys(test.data)
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("Want no error for %q got %s", test.data, err)
+		case test.want != "" && (err == nil || err.Error() != test.want):
+			t.Errorf("Want error %q got %v", test.want, err)
+		}
+	}
+}
+
+func TestTemplatePluginConvertServer(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "plugin.yaml",
+			data: "kind: pipeline\nname: default\nsteps:\n- name: notify\n  image: plugins/slack\n  settings:\n    link: {{ .server.url }}/{{ .repo.Slug }}/{{ .build.after }}\n",
+			want: "link: https://drone.company.com/octocat/hello-world/3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		{
+			name: "plugin.star",
+			data: "def main(ctx):\n  return {'kind': 'pipeline', 'name': 'default', 'link': '%s/%s' % (ctx.server.url, ctx.repo.slug)}\n",
+			want: `"link": "https://drone.company.com/octocat/hello-world"`,
+		},
+		{
+			name: "plugin.jsonnet",
+			data: "{kind: 'pipeline', name: 'default', link: std.extVar('server.url') + '/' + std.extVar('repo.slug')}",
+			want: `"link": "https://drone.company.com/octocat/hello-world"`,
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: " + test.name + "\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      test.name,
+			Data:      test.data,
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		plugin := Template(templates, 0, 0, TemplateServer("https://drone.company.com/", "2.0.0"))
+		config, err := plugin.Convert(noContext, req)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if !strings.Contains(config.Data, test.want) {
+			t.Errorf("%s: want %q in %q", test.name, test.want, config.Data)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertServerUnset(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: \"default{{ .server.url }}{{ .server.version }}\"\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	config, err := Template(templates, 0, 0).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+	} else if want := "kind: pipeline\nname: \"default\"\n"; config.Data != want {
+		t.Errorf("Want empty server metadata, got %q", config.Data)
+	}
+}
+
+func TestTemplatePluginConvertValues(t *testing.T) {
+	tests := []struct {
+		values *core.Template
+		want   string
+	}{
+		{
+			values: &core.Template{
+				Name:      "values.yaml",
+				Data:      "registry: registry.company.com\ngo:\n  version: \"1.16\"\n",
+				Namespace: "octocat",
+			},
+			want: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: registry.company.com/golang:1.16\n",
+		},
+		{
+			// the values are empty without a values file.
+			want: "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: docker.io/golang:latest\n",
+		},
+	}
+
+	for _, test := range tests {
+		req := &core.ConvertArgs{
+			Build: &core.Build{
+				After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+			},
+			Repo: &core.Repository{
+				Slug:      "octocat/hello-world",
+				Config:    ".drone.yml",
+				Namespace: "octocat",
+			},
+			Config: &core.Config{
+				Data: "kind: template\nload: plugin.yaml\n",
+			},
+		}
+
+		template := &core.Template{
+			Name:      "plugin.yaml",
+			Data:      "kind: pipeline\nname: default\nsteps:\n- name: build\n  image: {{ or .values.registry \"docker.io\" }}/golang:{{ if .values.go }}{{ .values.go.version }}{{ else }}latest{{ end }}\n",
+			Namespace: "octocat",
+		}
+
+		controller := gomock.NewController(t)
+
+		templates := mock.NewMockTemplateStore(controller)
+		if test.values != nil {
+			templates.EXPECT().FindName(gomock.Any(), "values.yaml", req.Repo.Namespace).Return(test.values, nil)
+		} else {
+			templates.EXPECT().FindName(gomock.Any(), "values.yaml", req.Repo.Namespace).Return(nil, sql.ErrNoRows)
+		}
+		templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+		config, err := Template(templates, 0, 0, TemplateValues("values.yaml")).Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if got := config.Data; got != test.want {
+			t.Errorf("Want %q got %q", test.want, got)
+		}
+		controller.Finish()
+	}
+}
+
+func TestTemplatePluginConvertMaxDataSize(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\ndata:\n  name: test\n  image: golang\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: {{ .input.name }}\nsteps:\n- name: build\n  image: {{ .input.image }}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	// the template is not resolved when the data block
+	// exceeds the limit.
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	if _, err := Template(templates, 0, 0, TemplateMaxDataSize(64)).Convert(noContext, req); err != nil {
+		t.Errorf("Want data block within the limit, got %s", err)
+	}
+
+	want := "template converter: data block is 25 bytes, exceeding the maximum of 16 bytes"
+	_, err := Template(templates, 0, 0, TemplateMaxDataSize(16)).Convert(noContext, req)
+	if err == nil || err.Error() != want {
+		t.Errorf("Want error %q got %v", want, err)
+	}
+}
+
+func TestTemplatePluginConvertSeparatorStyle(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: template\nload: plugin.yaml\ndata:\n  name: build\n---\nkind: template\nload: plugin.yaml\ndata:\n  name: deploy\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: {{ .input.name }}\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil).Times(4)
+
+	tests := []struct {
+		style SeparatorStyle
+		want  string
+	}{
+		{
+			style: SeparatorLeading,
+			want:  "---\nkind: pipeline\nname: build\n---\nkind: pipeline\nname: deploy\n",
+		},
+		{
+			style: SeparatorBetween,
+			want:  "kind: pipeline\nname: build\n---\nkind: pipeline\nname: deploy\n",
+		},
+	}
+	for _, test := range tests {
+		config, err := Template(templates, 0, 0, TemplateSeparatorStyle(test.style)).Convert(noContext, req)
+		if err != nil {
+			t.Error(err)
+		} else if got := config.Data; got != test.want {
+			t.Errorf("Want separator style %d %q got %q", test.style, test.want, got)
+		}
+	}
+}
+
+func TestTemplatePluginConvertDocumentTransform(t *testing.T) {
+	req := &core.ConvertArgs{
+		Build: &core.Build{
+			After: "3d21ec53a331a6f037a91c368710b99387d012c1",
+		},
+		Repo: &core.Repository{
+			Slug:      "octocat/hello-world",
+			Config:    ".drone.yml",
+			Namespace: "octocat",
+		},
+		Config: &core.Config{
+			Data: "kind: pipeline\nname: raw\n---\nkind: template\nload: plugin.yaml\n",
+		},
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      "kind: pipeline\nname: build\n---\nkind: pipeline\nname: deploy\n",
+		Namespace: "octocat",
+	}
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	templates := mock.NewMockTemplateStore(controller)
+	templates.EXPECT().FindName(gomock.Any(), template.Name, req.Repo.Namespace).Return(template, nil)
+
+	// the transform labels documents rendered by templates.
+	got := map[string]bool{}
+	transform := func(ctx context.Context, repo *core.Repository, doc string, rendered bool) (string, error) {
+		name := strings.TrimSpace(doc[strings.Index(doc, "name:")+len("name:"):])
+		got[name] = rendered
+		if rendered {
+			doc += "labels:\n  rendered: true\n"
+		}
+		return doc, nil
+	}
+
+	config, err := Template(templates, 0, 0, TemplateDocumentTransform(transform)).Convert(noContext, req)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	want := map[string]bool{"raw": false, "build": true, "deploy": true}
+	for name, rendered := range want {
+		if got[name] != rendered {
+			t.Errorf("Want document %q rendered %v got %v", name, rendered, got[name])
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("Want %d documents transformed got %d", len(want), len(got))
+	}
+	if n := strings.Count(config.Data, "rendered: true"); n != 2 {
+		t.Errorf("Want 2 documents transformed, got %q", config.Data)
+	}
+}
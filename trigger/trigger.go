@@ -215,6 +215,14 @@ func (t *triggerer) Trigger(ctx context.Context, repo *core.Repository, base *co
 		return t.createBuildError(ctx, repo, base, err.Error())
 	}
 
+	// the conversion service may determine there is
+	// nothing to build (e.g. a template that skips
+	// documentation changes).
+	if raw.Kind == core.ConfigKindSkip {
+		logger.Infoln("trigger: skipping build, conversion requested skip")
+		return nil, nil
+	}
+
 	// this code is temporarily in place to detect and convert
 	// the legacy yaml configuration file to the new format.
 	raw.Data, err = converter.ConvertString(raw.Data, converter.Metadata{
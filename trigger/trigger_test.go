@@ -16,6 +16,7 @@ import (
 
 	"github.com/drone/drone/core"
 	"github.com/drone/drone/mock"
+	"github.com/drone/drone/plugin/converter"
 	"github.com/sirupsen/logrus"
 
 	"github.com/golang/mock/gomock"
@@ -301,6 +302,109 @@ func TestTrigger_SkipBranch(t *testing.T) {
 	}
 }
 
+// this test verifies that no build should be scheduled if the
+// converted configuration file requests the build be skipped.
+func TestTrigger_SkipConvert(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	mockUsers := mock.NewMockUserStore(controller)
+	mockUsers.EXPECT().Find(noContext, dummyRepo.UserID).Return(dummyUser, nil)
+
+	mockConfigService := mock.NewMockConfigService(controller)
+	mockConfigService.EXPECT().Find(gomock.Any(), gomock.Any()).Return(dummyYaml, nil)
+
+	mockConvertService := mock.NewMockConvertService(controller)
+	mockConvertService.EXPECT().Convert(gomock.Any(), gomock.Any()).Return(&core.Config{Kind: core.ConfigKindSkip, Data: "kind: skip"}, nil)
+
+	triggerer := New(
+		nil,
+		mockConfigService,
+		mockConvertService,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		mockUsers,
+		nil,
+		nil,
+	)
+
+	build, err := triggerer.Trigger(noContext, dummyRepo, dummyHook)
+	if err != nil {
+		t.Errorf("Expect build silently skipped if conversion requests skip")
+	}
+	if build != nil {
+		t.Errorf("Expect nil build if conversion requests skip")
+	}
+}
+
+// this test verifies that the provenance record emitted by the
+// template converter does not create an additional stage.
+func TestTrigger_Provenance(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	checkBuild := func(_ context.Context, build *core.Build, stages []*core.Stage) {
+		if diff := cmp.Diff(stages, dummyStages, ignoreStageFields); diff != "" {
+			t.Errorf(diff)
+		}
+	}
+
+	template := &core.Template{
+		Name:      "plugin.yaml",
+		Data:      dummyYaml.Data,
+		Namespace: dummyRepo.Namespace,
+	}
+
+	mockTemplates := mock.NewMockTemplateStore(controller)
+	mockTemplates.EXPECT().FindName(gomock.Any(), template.Name, template.Namespace).Return(template, nil)
+
+	mockUsers := mock.NewMockUserStore(controller)
+	mockUsers.EXPECT().Find(gomock.Any(), dummyRepo.UserID).Return(dummyUser, nil)
+
+	mockRepos := mock.NewMockRepositoryStore(controller)
+	mockRepos.EXPECT().Increment(gomock.Any(), dummyRepo).Return(dummyRepo, nil)
+
+	mockConfigService := mock.NewMockConfigService(controller)
+	mockConfigService.EXPECT().Find(gomock.Any(), gomock.Any()).Return(&core.Config{Data: "kind: template\nload: plugin.yaml\n"}, nil)
+
+	mockValidateService := mock.NewMockValidateService(controller)
+	mockValidateService.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil)
+
+	mockStatus := mock.NewMockStatusService(controller)
+	mockStatus.EXPECT().Send(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+
+	mockQueue := mock.NewMockScheduler(controller)
+	mockQueue.EXPECT().Schedule(gomock.Any(), gomock.Any()).Return(nil)
+
+	mockBuilds := mock.NewMockBuildStore(controller)
+	mockBuilds.EXPECT().Create(gomock.Any(), gomock.Any(), gomock.Any()).Do(checkBuild).Return(nil)
+
+	mockWebhooks := mock.NewMockWebhookSender(controller)
+	mockWebhooks.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil)
+
+	triggerer := New(
+		nil,
+		mockConfigService,
+		converter.Template(mockTemplates, 0, 0, converter.TemplateEmitProvenance(true)),
+		nil,
+		mockStatus,
+		mockBuilds,
+		mockQueue,
+		mockRepos,
+		mockUsers,
+		mockValidateService,
+		mockWebhooks,
+	)
+
+	_, err := triggerer.Trigger(noContext, dummyRepo, dummyHook)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 // this test verifies that no build should be scheduled if the
 // hook event does not match the events defined in the yaml.
 func TestTrigger_SkipEvent(t *testing.T) {